@@ -3,7 +3,9 @@ package rex
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"net/http"
+	"path"
 	"runtime"
 	"strings"
 	"time"
@@ -20,9 +22,22 @@ type APIHandler struct {
 	// Prefix to add prefix for each api path, like "v2"
 	Prefix string
 
-	middlewares []Handle
-	queries     map[string][]Handle
-	mutations   map[string][]Handle
+	middlewares    []Handle
+	queries        map[string][]Handle
+	mutations      map[string][]Handle
+	resources      map[string][]Handle
+	docs           map[string]*EndpointDoc
+	trustedProxies []*net.IPNet
+}
+
+// SetTrustedProxies sets the CIDR list of trusted proxies to resolve the client IP.
+func (a *APIHandler) SetTrustedProxies(cidrs ...string) error {
+	nets, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		return err
+	}
+	a.trustedProxies = nets
+	return nil
 }
 
 // Use appends middlewares to current APIS middleware stack.
@@ -35,7 +50,7 @@ func (a *APIHandler) Use(middlewares ...Handle) {
 }
 
 // Query adds a query api
-func (a *APIHandler) Query(endpoint string, handles ...Handle) {
+func (a *APIHandler) Query(endpoint string, handles ...Handle) *Endpoint {
 	endpoint = utils.CleanPath(endpoint)[1:]
 	if endpoint != "" {
 		if a.queries == nil {
@@ -47,10 +62,11 @@ func (a *APIHandler) Query(endpoint string, handles ...Handle) {
 			}
 		}
 	}
+	return &Endpoint{a, "GET", endpoint}
 }
 
 // Mutation adds a mutation api
-func (a *APIHandler) Mutation(endpoint string, handles ...Handle) {
+func (a *APIHandler) Mutation(endpoint string, handles ...Handle) *Endpoint {
 	endpoint = utils.CleanPath(endpoint)[1:]
 	if endpoint != "" {
 		if a.mutations == nil {
@@ -62,22 +78,25 @@ func (a *APIHandler) Mutation(endpoint string, handles ...Handle) {
 			}
 		}
 	}
+	return &Endpoint{a, "POST", endpoint}
 }
 
 // ServeHTTP implements the http Handler.
 func (a *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	wr := &responseWriter{status: 200, rawWriter: w}
-	form := &Form{r}
+	form := &Form{R: r}
 	store := &Store{}
 	ctx := &Context{
-		W:           wr,
-		R:           r,
-		Form:        form,
-		Store:       store,
-		sidStore:    defaultSIDStore,
-		sessionPool: defaultSessionPool,
-		logger:      &log.Logger{},
+		W:              wr,
+		R:              r,
+		Form:           form,
+		Store:          store,
+		sidStore:       defaultSIDStore,
+		sessionPool:    defaultSessionPool,
+		logger:         &log.Logger{},
+		trustedProxies: a.trustedProxies,
+		api:            a,
 	}
 
 	defer func() {
@@ -128,68 +147,109 @@ func (a *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	var apiHandles map[string][]Handle
-	switch r.Method {
-	case "GET":
-		apiHandles = a.queries
-	case "POST":
-		apiHandles = a.mutations
-	default:
-		ctx.ejson(&Error{http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)})
-		return
+	isQueryOrMutation := r.Method == "GET" || r.Method == "POST"
+	if !isQueryOrMutation {
+		if len(a.resources) == 0 {
+			ctx.ejson(&Error{http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)})
+			return
+		}
 	}
 
-	pathname := r.URL.Path
-	if a.Prefix != "" {
-		pathname = strings.TrimPrefix(pathname, "/"+strings.Trim(a.Prefix, "/"))
-	}
+	pathname := a.trimPrefix(r.URL.Path)
 	path := &Path{
 		segments: strings.Split(utils.CleanPath(pathname), "/")[1:],
 	}
 
-	for _, handle := range a.middlewares {
-		ctx.W, ctx.R, ctx.Path, ctx.Form, ctx.Store = wr, r, path, form, store
-		v := handle(ctx)
-		if v != nil {
-			ctx.end(v)
-			return
+	// route appends the handles of the matched endpoint to the chain
+	route := func(ctx *Context) interface{} {
+		endpoint, handles, ok := a.match(r.Method, path.segments)
+		if !ok && !isQueryOrMutation {
+			return &Error{http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)}
 		}
+		if !ok {
+			return &Error{404, "not found"}
+		}
+		if n := len(handles); n > 0 && len(ctx.chain.deferred) > 0 {
+			// the deferred handles run before the last handle of the endpoint
+			handles = append(append(append([]Handle{}, handles[:n-1]...), ctx.chain.deferred...), handles[n-1])
+		}
+		ctx.chain.endpoint = endpoint
+		ctx.chain.routed = len(ctx.chain.handles)
+		ctx.chain.handles = append(ctx.chain.handles, handles...)
+		return nil
 	}
 
-	var handles []Handle
-	var ok bool
-	if len(path.segments) > 0 {
-		handles, ok = apiHandles[strings.Join(path.segments, "/")]
+	ctx.chain = &handleChain{
+		handles: append(a.middlewares[:len(a.middlewares):len(a.middlewares)], route),
+		rw:      wr,
+		w:       wr,
+		r:       r,
+		path:    path,
+		form:    form,
+		store:   store,
 	}
-	if !ok {
-		for p, a := range apiHandles {
-			ps := strings.Split(p, "/")
-			if len(ps) > 1 && len(ps) == len(path.segments) {
-				matched := true
-				for i, s := range ps {
-					if s != "*" && s != path.segments[i] {
-						matched = false
-						break
-					}
-				}
-				if matched {
-					handles = a
-					ok = true
-					break
-				}
-			}
-		}
+	ctx.next()
+}
+
+// trimPrefix trims the Prefix of the pathname by the path segments, the
+// pathname without the Prefix is not changed, like the requests of the proxies
+// that strip the prefix.
+func (a *APIHandler) trimPrefix(pathname string) string {
+	prefix := strings.Trim(a.Prefix, "/")
+	if prefix == "" {
+		return pathname
 	}
-	if !ok {
-		handles, ok = apiHandles["*"]
+	prefix = "/" + prefix
+	if pathname == prefix {
+		return "/"
 	}
-	if !ok {
-		ctx.ejson(&Error{404, "not found"})
+	if strings.HasPrefix(pathname, prefix+"/") {
+		return pathname[len(prefix):]
+	}
+	return pathname
+}
+
+// Mount registers the APIHandler to the mux under the prefix, the prefix is
+// prepended to the Prefix of the APIHandler, like "/api" and "v2" are
+// composed to "api/v2". The handles see the path without the Prefix.
+func (a *APIHandler) Mount(mux *http.ServeMux, prefix string) {
+	prefix = strings.Trim(path.Join("/", prefix, a.Prefix), "/")
+	a.Prefix = prefix
+	if prefix == "" {
+		mux.Handle("/", a)
 		return
 	}
+	mux.Handle("/"+prefix, a)
+	mux.Handle("/"+prefix+"/", a)
+}
 
-	for _, handle := range handles {
-		if len(ctx.acl) > 0 {
+// handleChain is the chain of the handles of a request.
+type handleChain struct {
+	handles []Handle
+	index   int
+	// routed is the index of the first endpoint handle, the endpoint handles are checked by the ACL
+	routed int
+	// endpoint is the pattern of the matched endpoint, like "post/*"
+	endpoint string
+	// deferred are the middlewares that run after the ACL and auth handles of the endpoint
+	deferred []Handle
+	// rw is the response writer of rex, the w may be replaced by the standard middlewares
+	rw    *responseWriter
+	w     http.ResponseWriter
+	r     *http.Request
+	path  *Path
+	form  *Form
+	store *Store
+}
+
+// next calls the rest handles of the chain until a handle returns a non-nil value.
+func (ctx *Context) next() {
+	c := ctx.chain
+	for c.index < len(c.handles) {
+		handle := c.handles[c.index]
+		c.index++
+
+		if c.routed > 0 && c.index > c.routed && len(ctx.acl) > 0 {
 			var isGranted bool
 			if ctx.aclUser != nil {
 				for _, id := range ctx.aclUser.Permissions() {
@@ -205,7 +265,7 @@ func (a *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		ctx.W, ctx.R, ctx.Path, ctx.Form, ctx.Store = wr, r, path, form, store
+		ctx.W, ctx.R, ctx.Path, ctx.Form, ctx.Store = c.w, c.r, c.path, c.form, c.store
 		v := handle(ctx)
 		if v != nil {
 			ctx.end(v)
@@ -213,3 +273,69 @@ func (a *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// match returns the pattern and the handles of the endpoint that matches the
+// segments, the resources are matched after the queries and mutations, and the
+// "*" endpoint is the fallback.
+func (a *APIHandler) match(method string, segments []string) (endpoint string, handles []Handle, ok bool) {
+	var apiHandles map[string][]Handle
+	switch method {
+	case "GET":
+		apiHandles = a.queries
+	case "POST":
+		apiHandles = a.mutations
+	}
+	if endpoint, handles, ok = matchHandles(apiHandles, segments); ok {
+		return
+	}
+	if endpoint, handles, ok = matchHandles(a.resources, segments); ok {
+		return
+	}
+	handles, ok = apiHandles["*"]
+	return "*", handles, ok
+}
+
+// endpoint returns the pattern of the endpoint of the request, the request is
+// matched by the APIHandler if the endpoint is not routed yet.
+func (ctx *Context) endpoint() string {
+	if c := ctx.chain; c != nil && c.routed > 0 {
+		return c.endpoint
+	}
+	if ctx.api != nil && ctx.Path != nil {
+		if endpoint, _, ok := ctx.api.match(ctx.R.Method, ctx.Path.segments); ok {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+func matchHandles(apiHandles map[string][]Handle, segments []string) (endpoint string, handles []Handle, ok bool) {
+	if len(segments) > 0 {
+		endpoint = strings.Join(segments, "/")
+		handles, ok = apiHandles[endpoint]
+	}
+	if !ok {
+		for p, a := range apiHandles {
+			ps := strings.Split(p, "/")
+			if len(ps) > 1 && len(ps) == len(segments) {
+				matched := true
+				for i, s := range ps {
+					if s != "*" && s != segments[i] {
+						matched = false
+						break
+					}
+				}
+				if matched {
+					endpoint = p
+					handles = a
+					ok = true
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		endpoint = ""
+	}
+	return
+}
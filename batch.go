@@ -0,0 +1,217 @@
+package rex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+)
+
+type batchContextKey struct{}
+
+// BatchConfig contains options for the batch endpoint.
+type BatchConfig struct {
+	// MaxRequests is the max count of the sub-requests, defaults to 20
+	MaxRequests int
+	// Concurrency is the max count of queries running in parallel, defaults to 4
+	Concurrency int
+	// MaxBodySize is the max bytes of the batch body, defaults to 1MB
+	MaxBodySize int64
+}
+
+type batchRequest struct {
+	Type     string                 `json:"type"`
+	Endpoint string                 `json:"endpoint"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+type batchResult struct {
+	Status int         `json:"status"`
+	Result interface{} `json:"result"`
+}
+
+// Batch adds a batch mutation endpoint. The client posts a JSON array of
+// `{"type": "query"|"mutation", "endpoint", "params"}`, each sub-request runs
+// through the routing, middlewares and ACL with its own Context. The queries
+// between two mutations run in parallel, the mutations run in order.
+func (a *APIHandler) Batch(endpoint string, config BatchConfig) {
+	if config.MaxRequests <= 0 {
+		config.MaxRequests = 20
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 4
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	a.Mutation(endpoint, func(ctx *Context) interface{} {
+		if ctx.R.Context().Value(batchContextKey{}) != nil {
+			return &Error{400, "nested batch request"}
+		}
+
+		var reqs []batchRequest
+		decoder := json.NewDecoder(newLimitedBody(ctx.R.Body, config.MaxBodySize, ctx.Form.body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&reqs); err != nil {
+			if e, ok := err.(*Error); ok {
+				// the body is larger than the MaxBodySize
+				return e
+			}
+			return &Error{400, "invalid batch body"}
+		}
+		if len(reqs) > config.MaxRequests {
+			return &Error{400, fmt.Sprintf("too many requests in batch, max %d", config.MaxRequests)}
+		}
+
+		results := make([]batchResult, len(reqs))
+		cookies := make([][]string, len(reqs))
+		sem := make(chan struct{}, config.Concurrency)
+		var wg sync.WaitGroup
+		run := func(i int) {
+			results[i], cookies[i] = a.serveBatchRequest(ctx.R, reqs[i])
+		}
+		for i, req := range reqs {
+			if req.Type == "query" {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					run(i)
+				}(i)
+			} else {
+				// mutations wait for the previous queries and run in order
+				wg.Wait()
+				run(i)
+			}
+		}
+		wg.Wait()
+
+		for _, list := range cookies {
+			for _, cookie := range list {
+				ctx.AddHeader("Set-Cookie", cookie)
+			}
+		}
+		return results
+	})
+}
+
+// Batch adds a batch endpoint to the default APIHandler.
+func Batch(endpoint string, config BatchConfig) {
+	defaultAPIHanlder.Batch(endpoint, config)
+}
+
+func (a *APIHandler) serveBatchRequest(parent *http.Request, req batchRequest) (batchResult, []string) {
+	var method string
+	switch req.Type {
+	case "query":
+		method = "GET"
+	case "mutation":
+		method = "POST"
+	default:
+		return batchResult{400, map[string]interface{}{"error": &Error{400, "invalid request type"}}}, nil
+	}
+
+	pathname := utils.CleanPath(req.Endpoint)
+	if a.Prefix != "" {
+		pathname = path.Join("/", strings.Trim(a.Prefix, "/"), pathname)
+	}
+	values := url.Values{}
+	for key, value := range req.Params {
+		flattenParam(values, key, value)
+	}
+
+	u := &url.URL{Path: pathname}
+	var body io.Reader
+	if method == "GET" {
+		u.RawQuery = values.Encode()
+	} else {
+		body = strings.NewReader(values.Encode())
+	}
+	r, err := http.NewRequestWithContext(context.WithValue(parent.Context(), batchContextKey{}, true), method, u.String(), body)
+	if err != nil {
+		return batchResult{400, map[string]interface{}{"error": &Error{400, err.Error()}}}, nil
+	}
+	r.Host = parent.Host
+	r.RemoteAddr = parent.RemoteAddr
+	r.TLS = parent.TLS
+	r.RequestURI = u.RequestURI()
+	for key, vv := range parent.Header {
+		switch key {
+		case "Content-Type", "Content-Length", "Accept-Encoding", "If-None-Match", "If-Modified-Since", "If-Match", "Range":
+			continue
+		}
+		r.Header[key] = vv
+	}
+	if method == "POST" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := &batchResponseWriter{header: http.Header{}, status: 200}
+	a.ServeHTTP(w, r)
+
+	var result interface{}
+	if data := bytes.TrimSpace(w.body.Bytes()); len(data) > 0 && strings.HasPrefix(w.header.Get("Content-Type"), "application/json") {
+		result = json.RawMessage(data)
+	} else if w.body.Len() > 0 {
+		result = w.body.String()
+	}
+	return batchResult{w.status, result}, w.header.Values("Set-Cookie")
+}
+
+// flattenParam flattens the JSON param into the form values, the objects
+// are flattened to `key[sub]` and the arrays to repeated keys.
+func flattenParam(values url.Values, key string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		values.Add(key, v)
+	case json.Number:
+		values.Add(key, v.String())
+	case bool:
+		values.Add(key, fmt.Sprint(v))
+	case []interface{}:
+		for _, item := range v {
+			flattenParam(values, key, item)
+		}
+	case map[string]interface{}:
+		for sub, item := range v {
+			flattenParam(values, key+"["+sub+"]", item)
+		}
+	default:
+		values.Add(key, fmt.Sprint(v))
+	}
+}
+
+type batchResponseWriter struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(p)
+}
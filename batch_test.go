@@ -0,0 +1,115 @@
+package rex
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchOrdering(t *testing.T) {
+	var counter, running, maxRunning int64
+	api := &APIHandler{}
+	api.Batch("batch", BatchConfig{MaxRequests: 10, Concurrency: 2})
+	api.Query("counter", func(ctx *Context) interface{} {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			max := atomic.LoadInt64(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+				break
+			}
+		}
+		// the later query finishes first
+		delay, _ := ctx.Form.Int("delay")
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+		return atomic.LoadInt64(&counter)
+	})
+	api.Mutation("incr", func(ctx *Context) interface{} {
+		return atomic.AddInt64(&counter, ctx.Form.RequireInt("n"))
+	})
+
+	body := `[
+		{"type": "query", "endpoint": "counter", "params": {"delay": 20}},
+		{"type": "query", "endpoint": "counter", "params": {"delay": 10}},
+		{"type": "query", "endpoint": "counter", "params": {"delay": 0}},
+		{"type": "mutation", "endpoint": "incr", "params": {"n": 1}},
+		{"type": "query", "endpoint": "counter"},
+		{"type": "mutation", "endpoint": "incr", "params": {"n": 2}},
+		{"type": "mutation", "endpoint": "incr", "params": {"n": 3}},
+		{"type": "query", "endpoint": "counter"},
+		{"type": "query", "endpoint": "missing"},
+		{"type": "invalid", "endpoint": "counter"}
+	]`
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	var results []struct {
+		Status int             `json:"status"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	// the numbers are replied as text
+	want := []struct {
+		status int
+		result string
+	}{
+		{200, `"0"`}, {200, `"0"`}, {200, `"0"`},
+		{200, `"1"`}, {200, `"1"`},
+		{200, `"3"`}, {200, `"6"`}, {200, `"6"`},
+		{404, ""}, {400, ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, ret := range results {
+		if ret.Status != want[i].status || (want[i].result != "" && string(ret.Result) != want[i].result) {
+			t.Errorf("#%d: got %d %s, want %d %s", i, ret.Status, ret.Result, want[i].status, want[i].result)
+		}
+	}
+	if n := atomic.LoadInt64(&maxRunning); n > 2 {
+		t.Errorf("got %d queries running in parallel, want at most 2", n)
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	api := &APIHandler{}
+	api.Batch("batch", BatchConfig{MaxRequests: 2, MaxBodySize: 256})
+	api.Query("echo", func(ctx *Context) interface{} {
+		return ctx.Form.Value("a")
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"ok", `[{"type": "query", "endpoint": "echo", "params": {"a": "x"}}]`, 200},
+		{"invalid body", `{`, 400},
+		{"too many requests", `[{"type": "query", "endpoint": "echo"}, {"type": "query", "endpoint": "echo"}, {"type": "query", "endpoint": "echo"}]`, 400},
+		{"too large body", `[{"type": "query", "endpoint": "echo", "params": {"a": "` + strings.Repeat("x", 256) + `"}}]`, 413},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/batch", strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, w.Code, w.Body.String(), test.status)
+		}
+	}
+
+	// the batch endpoint can't be nested
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(`[{"type": "mutation", "endpoint": "batch"}]`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"status":400`) {
+		t.Errorf("nested batch: got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,439 @@
+package rex
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheConfig contains options for the Cache middleware.
+type CacheConfig struct {
+	// TTL is the fresh time of the cached responses, defaults to 1 minute
+	TTL time.Duration
+	// StaleWhileRevalidate is the time to serve the stale responses after the TTL while revalidating in background
+	StaleWhileRevalidate time.Duration
+	// QueryParams are the query params in the cache key, nil for all the query params
+	QueryParams []string
+	// Vary are the request headers in the cache key
+	Vary []string
+	// PerUser caches the responses per user by the basic auth user or the session id
+	PerUser bool
+	// Tags are used to invalidate the cached responses by ctx.InvalidateCache
+	Tags []string
+	// MaxBodySize is the max size of the cached response body, defaults to 1MB
+	MaxBodySize int
+	// Name prefixes the keys to share a store between caches
+	Name string
+	// Store defaults to a LRU store of 1000 entries that is shared by the caches
+	// without a store, the responses in a custom store are invalidated by its
+	// InvalidateTags method
+	Store CacheStore
+}
+
+// CacheEntry is a cached response.
+type CacheEntry struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Tags    []string
+	Created time.Time
+	Expires time.Time
+	// StaleUntil is the deadline to serve the entry while revalidating
+	StaleUntil time.Time
+}
+
+// A CacheStore interface to store the cached responses.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	InvalidateTags(tags ...string)
+}
+
+var (
+	cacheSeq       uint64
+	cacheStoreOnce sync.Once
+	cacheStore     CacheStore
+)
+
+// defaultCacheStore returns the LRU store that is shared by the caches without
+// a store.
+func defaultCacheStore() CacheStore {
+	cacheStoreOnce.Do(func() {
+		cacheStore = NewLRUCacheStore(1000)
+	})
+	return cacheStore
+}
+
+// InvalidateCache removes the cached responses with the tags from the shared
+// store of the Cache middlewares.
+func InvalidateCache(tags ...string) {
+	defaultCacheStore().InvalidateTags(tags...)
+}
+
+// InvalidateCache removes the cached responses with the tags, it's usually
+// called by the mutations that change the cached data.
+func (ctx *Context) InvalidateCache(tags ...string) {
+	InvalidateCache(tags...)
+}
+
+type cacheCall struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *cacheCall) release(inflight *sync.Map, key string) {
+	c.once.Do(func() {
+		inflight.Delete(key)
+		close(c.done)
+	})
+}
+
+type cacheHit struct {
+	entry *CacheEntry
+	state string
+}
+
+// Cache returns a Cache middleware that caches the full responses of the
+// queries, the compressed responses are cached by the encoding. Only one
+// handler runs for the same key at the same time, other requests wait for
+// its response. Used by the Use, the middleware runs before the last handle of
+// the matched endpoint, so the ACL and auth handles of the endpoint are always
+// checked before a cached response is served. The stale response is
+// revalidated by the rest handles of the endpoint in background.
+func Cache(config CacheConfig) Handle {
+	if config.TTL <= 0 {
+		config.TTL = time.Minute
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	if config.Store == nil {
+		config.Store = defaultCacheStore()
+		if config.Name == "" {
+			// separate the keys of the caches in the shared store
+			config.Name = "#" + strconv.FormatUint(atomic.AddUint64(&cacheSeq, 1), 10)
+		}
+	}
+	store := config.Store
+	inflight := &sync.Map{}
+
+	// record records the response of the writer to the store when it's closed
+	record := func(w *responseWriter, call *cacheCall, key string) bool {
+		rec := &cacheRecorder{ResponseWriter: w.rawWriter, maxBodySize: config.MaxBodySize}
+		if w.compression != nil {
+			// the compression is enabled before, the compressor writes to the recorder
+			c, ok := w.compression.(*pooledCompressor)
+			if !ok {
+				return false
+			}
+			c.Reset(rec)
+		}
+		w.rawWriter = rec
+		w.onClose = append(w.onClose, func() {
+			defer call.release(inflight, key)
+			if rec.status != 200 || rec.exceeded || len(rec.header["Set-Cookie"]) > 0 {
+				return
+			}
+			now := time.Now()
+			store.Set(key, &CacheEntry{
+				Status:     rec.status,
+				Header:     rec.header,
+				Body:       rec.body.Bytes(),
+				Tags:       config.Tags,
+				Created:    now,
+				Expires:    now.Add(config.TTL),
+				StaleUntil: now.Add(config.TTL + config.StaleWhileRevalidate),
+			})
+		})
+		return true
+	}
+
+	var handle Handle
+	handle = func(ctx *Context) interface{} {
+		if ctx.R.Method != "GET" {
+			return nil
+		}
+		c := ctx.chain
+		if c != nil && c.routed == 0 {
+			// the endpoint is not matched yet, defer to run after its ACL and auth handles
+			c.deferred = append(c.deferred, handle)
+			return nil
+		}
+		w, ok := ctx.responseWriter()
+		if !ok || w.headerSent {
+			return nil
+		}
+		key := cacheKey(ctx, &config)
+		if config.Name != "" {
+			key = config.Name + "|" + key
+		}
+
+		entry, ok := store.Get(key)
+		now := time.Now()
+		if ok && now.Before(entry.Expires) {
+			return &cacheHit{entry, "HIT"}
+		}
+		stale := ok && now.Before(entry.StaleUntil)
+
+		call := &cacheCall{done: make(chan struct{})}
+		if v, loaded := inflight.LoadOrStore(key, call); loaded {
+			if stale {
+				return &cacheHit{entry, "STALE"}
+			}
+			// wait for the response of the running handler
+			select {
+			case <-v.(*cacheCall).done:
+			case <-ctx.R.Context().Done():
+				return &Error{http.StatusServiceUnavailable, ctx.R.Context().Err().Error()}
+			}
+			if entry, ok := store.Get(key); ok && time.Now().Before(entry.StaleUntil) {
+				return &cacheHit{entry, "HIT"}
+			}
+			return nil
+		}
+		if stale && c != nil {
+			rw := &responseWriter{status: 200, rawWriter: &batchResponseWriter{header: http.Header{}, status: 200}}
+			rc := ctx.detach(rw)
+			go func() {
+				defer call.release(inflight, key)
+				defer func() {
+					if v := recover(); v != nil && ctx.logger != nil {
+						if _, ok := v.(*recoverError); !ok {
+							ctx.logger.Printf("[panic] cache revalidation: %v", v)
+						}
+					}
+				}()
+				if record(rw, call, key) {
+					rc.next()
+					rw.Close()
+				}
+			}()
+			return &cacheHit{entry, "STALE"}
+		}
+
+		if !record(w, call, key) {
+			call.release(inflight, key)
+			return nil
+		}
+		ctx.SetHeader("X-Cache", "MISS")
+		return nil
+	}
+	return handle
+}
+
+// detach returns a copy of the context to run the rest handles of the chain
+// in background, the response is written to the w. The middlewares and the
+// ACL handles that have run are not run again.
+func (ctx *Context) detach(w *responseWriter) *Context {
+	c := ctx.chain
+	r := ctx.R.Clone(context.Background())
+	form := *c.form
+	form.R = r
+	rc := *ctx
+	rc.W, rc.R, rc.Form = w, r, &form
+	rc.chain = &handleChain{
+		handles:  c.handles[c.index:],
+		endpoint: c.endpoint,
+		rw:       w,
+		w:        w,
+		r:        r,
+		path:     c.path,
+		form:     &form,
+		store:    c.store,
+	}
+	return &rc
+}
+
+func cacheKey(ctx *Context, config *CacheConfig) string {
+	buf := bytes.NewBufferString(ctx.R.URL.Path)
+	query := ctx.R.URL.Query()
+	names := config.QueryParams
+	if names == nil {
+		for name := range query {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if values, ok := query[name]; ok {
+			buf.WriteByte('\n')
+			buf.WriteString(url.Values{name: values}.Encode())
+		}
+	}
+	for _, name := range config.Vary {
+		buf.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(ctx.R.Header.Values(name), ","))
+	}
+	buf.WriteString("\nencoding: " + acceptEncoding(ctx.R, ctx.compressionConfig().Encodings))
+	if config.PerUser {
+		user := ctx.basicAuthUser
+		if user == "" && ctx.session != nil {
+			user = ctx.session.SID()
+		} else if user == "" && ctx.sidStore != nil {
+			user = ctx.sidStore.Get(ctx.R)
+		}
+		buf.WriteString("\nuser: " + user)
+	}
+	return buf.String()
+}
+
+func (ctx *Context) writeCacheHit(hit *cacheHit) {
+	h := ctx.W.Header()
+	if w, ok := ctx.responseWriter(); ok && w.compression != nil {
+		// the cached body is encoded already
+		w.dropCompression()
+		h.Del("Content-Encoding")
+	}
+	for key, values := range hit.entry.Header {
+		h[key] = values
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(hit.entry.Created)/time.Second)))
+	h.Set("X-Cache", hit.state)
+	if matchETag(ctx.R.Header.Get("If-None-Match"), h.Get("ETag")) {
+		h.Del("Content-Type")
+		h.Del("Content-Encoding")
+		ctx.W.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(hit.entry.Body)))
+	ctx.W.WriteHeader(hit.entry.Status)
+	ctx.W.Write(hit.entry.Body)
+}
+
+// cacheRecorder records the response that is written to the raw writer.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	maxBodySize int
+	exceeded    bool
+}
+
+func (w *cacheRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+		w.header.Del("X-Cache")
+		w.header.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(200)
+	}
+	if !w.exceeded {
+		if w.body.Len()+len(p) > w.maxBodySize {
+			w.exceeded = true
+			w.body.Reset()
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the raw response writer for the http.ResponseController.
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type lruCacheStore struct {
+	lock       sync.Mutex
+	maxEntries int
+	ll         *list.List
+	entries    map[string]*list.Element
+	tags       map[string]map[string]struct{}
+}
+
+type lruCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewLRUCacheStore returns a in-memory CacheStore that evicts the least
+// recently used entries.
+func NewLRUCacheStore(maxEntries int) CacheStore {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &lruCacheStore{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    map[string]*list.Element{},
+		tags:       map[string]map[string]struct{}{},
+	}
+}
+
+func (s *lruCacheStore) Get(key string) (*CacheEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := e.Value.(*lruCacheItem)
+	if time.Now().After(item.entry.StaleUntil) {
+		s.remove(e)
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return item.entry, true
+}
+
+func (s *lruCacheStore) Set(key string, entry *CacheEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+	s.entries[key] = s.ll.PushFront(&lruCacheItem{key, entry})
+	for _, tag := range entry.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = map[string]struct{}{}
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	for s.ll.Len() > s.maxEntries {
+		s.remove(s.ll.Back())
+	}
+}
+
+func (s *lruCacheStore) InvalidateTags(tags ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if e, ok := s.entries[key]; ok {
+				s.remove(e)
+			}
+		}
+		delete(s.tags, tag)
+	}
+}
+
+func (s *lruCacheStore) remove(e *list.Element) {
+	item := e.Value.(*lruCacheItem)
+	s.ll.Remove(e)
+	delete(s.entries, item.key)
+	for _, tag := range item.entry.Tags {
+		if keys, ok := s.tags[tag]; ok {
+			delete(keys, item.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
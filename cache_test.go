@@ -0,0 +1,147 @@
+package rex
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func cacheTestServe(api *APIHandler, method string, target string, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func TestCache(t *testing.T) {
+	var calls int64
+	api := &APIHandler{}
+	api.Use(Cache(CacheConfig{Tags: []string{"items"}}))
+	api.Query("items", func(ctx *Context) interface{} {
+		return "items-" + strconv.FormatInt(atomic.AddInt64(&calls, 1), 10)
+	})
+	api.Query("private", func(ctx *Context) interface{} {
+		ctx.SetHeader("Set-Cookie", "a=b")
+		return "private-" + strconv.FormatInt(atomic.AddInt64(&calls, 1), 10)
+	})
+	api.Mutation("items", func(ctx *Context) interface{} {
+		ctx.InvalidateCache("items")
+		return "ok"
+	})
+
+	tests := []struct {
+		method string
+		target string
+		cache  string
+		body   string
+	}{
+		{method: "GET", target: "/items", cache: "MISS", body: "items-1"},
+		{method: "GET", target: "/items", cache: "HIT", body: "items-1"},
+		{method: "GET", target: "/items?page=2", cache: "MISS", body: "items-2"},
+		{method: "POST", target: "/items", body: "ok"},
+		{method: "GET", target: "/items", cache: "MISS", body: "items-3"},
+		{method: "GET", target: "/items?page=2", cache: "MISS", body: "items-4"},
+		{method: "GET", target: "/private", cache: "MISS", body: "private-5"},
+		{method: "GET", target: "/private", cache: "MISS", body: "private-6"},
+	}
+	for i, test := range tests {
+		w := cacheTestServe(api, test.method, test.target, "")
+		if got := w.Header().Get("X-Cache"); got != test.cache || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("#%d %s %s: got %q %s, want %q %s", i, test.method, test.target, got, w.Body.String(), test.cache, test.body)
+		}
+	}
+}
+
+func TestCacheCompression(t *testing.T) {
+	data := strings.Repeat("hello world ", 200)
+	api := &APIHandler{}
+	api.Use(Cache(CacheConfig{}))
+	api.Query("early", func(ctx *Context) interface{} {
+		// the compression is enabled before the cache records the response
+		ctx.SetHeader("Content-Type", "text/plain")
+		ctx.EnableCompression()
+		return nil
+	}, func(ctx *Context) interface{} {
+		return data
+	})
+	api.Query("late", func(ctx *Context) interface{} {
+		return data
+	})
+
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		cache          string
+		encoding       string
+	}{
+		{target: "/early", acceptEncoding: "gzip", cache: "MISS", encoding: "gzip"},
+		{target: "/early", acceptEncoding: "gzip", cache: "HIT", encoding: "gzip"},
+		{target: "/early", acceptEncoding: "", cache: "MISS", encoding: ""},
+		{target: "/early", acceptEncoding: "", cache: "HIT", encoding: ""},
+		{target: "/late", acceptEncoding: "gzip", cache: "MISS", encoding: "gzip"},
+		{target: "/late", acceptEncoding: "gzip", cache: "HIT", encoding: "gzip"},
+	}
+	for i, test := range tests {
+		w := cacheTestServe(api, "GET", test.target, test.acceptEncoding)
+		if w.Header().Get("X-Cache") != test.cache || w.Header().Get("Content-Encoding") != test.encoding {
+			t.Errorf("#%d %s: got %q %q, want %q %q", i, test.target, w.Header().Get("X-Cache"), w.Header().Get("Content-Encoding"), test.cache, test.encoding)
+			continue
+		}
+		var r io.Reader = bytes.NewReader(w.Body.Bytes())
+		if test.encoding == "gzip" {
+			gr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Errorf("#%d %s: %v", i, test.target, err)
+				continue
+			}
+			r = gr
+		}
+		body, err := io.ReadAll(r)
+		if err != nil || !strings.Contains(string(body), data) {
+			t.Errorf("#%d %s: got body %.40q %v", i, test.target, body, err)
+		}
+	}
+}
+
+func TestCacheStaleRevalidation(t *testing.T) {
+	var middlewareCalls, calls int64
+	api := &APIHandler{}
+	api.Use(func(ctx *Context) interface{} {
+		atomic.AddInt64(&middlewareCalls, 1)
+		return nil
+	})
+	api.Use(Cache(CacheConfig{TTL: 20 * time.Millisecond, StaleWhileRevalidate: time.Minute}))
+	api.Query("items", func(ctx *Context) interface{} {
+		return "items-" + strconv.FormatInt(atomic.AddInt64(&calls, 1), 10)
+	})
+
+	if w := cacheTestServe(api, "GET", "/items", ""); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("got %q %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if w := cacheTestServe(api, "GET", "/items", ""); w.Header().Get("X-Cache") != "STALE" || !strings.Contains(w.Body.String(), "items-1") {
+		t.Fatalf("got %q %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&calls) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	// wait for the revalidated response to be stored
+	time.Sleep(5 * time.Millisecond)
+	if w := cacheTestServe(api, "GET", "/items", ""); w.Header().Get("X-Cache") != "HIT" || !strings.Contains(w.Body.String(), "items-2") {
+		t.Fatalf("got %q %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	// the middlewares are not run by the revalidation
+	if n := atomic.LoadInt64(&middlewareCalls); n != 3 {
+		t.Fatalf("got %d middleware calls, want 3", n)
+	}
+}
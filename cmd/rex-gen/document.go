@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type document struct {
+	Info struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string       `json:"operationId"`
+	Summary     string       `json:"summary"`
+	Deprecated  bool         `json:"deprecated"`
+	Parameters  []*parameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"responses"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	ContentEncoding      string             `json:"contentEncoding"`
+	Minimum              *float64           `json:"minimum"`
+	Items                *schema            `json:"items"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+}
+
+func (s *schema) refName() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+func (s *schema) isRequired(name string) bool {
+	for _, n := range s.Required {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schema) propertyNames() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// endpoint is a query or mutation of the document.
+type endpoint struct {
+	Name       string
+	Method     string
+	Path       string
+	Summary    string
+	Deprecated bool
+	// Params contains the path, query, header and form parameters
+	Params   []*parameter
+	Body     *schema
+	Response *schema
+}
+
+func (e *endpoint) hasRequiredParam() bool {
+	for _, p := range e.Params {
+		if p.Required {
+			return true
+		}
+	}
+	return false
+}
+
+func loadDocument(spec string) (*document, error) {
+	var r io.Reader
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		res, err := http.Get(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("fetch %s: %s", spec, res.Status)
+		}
+		r = res.Body
+	} else {
+		file, err := os.Open(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	var doc document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+	return &doc, nil
+}
+
+// endpoints returns the queries and mutations sorted by name.
+func (d *document) endpoints() []*endpoint {
+	var list []*endpoint
+	for pathname, item := range d.Paths {
+		for method, op := range item {
+			method = strings.ToUpper(method)
+			if method != "GET" && method != "POST" {
+				continue
+			}
+			e := &endpoint{
+				Name:       op.OperationID,
+				Method:     method,
+				Path:       pathname,
+				Summary:    op.Summary,
+				Deprecated: op.Deprecated,
+				Params:     op.Parameters,
+			}
+			if e.Name == "" {
+				e.Name = strings.ToLower(method) + exportName(pathname)
+			}
+			if op.RequestBody != nil {
+				if m, ok := op.RequestBody.Content["application/json"]; ok {
+					e.Body = m.Schema
+				}
+				if m, ok := op.RequestBody.Content["application/x-www-form-urlencoded"]; ok && m.Schema != nil {
+					for _, name := range m.Schema.propertyNames() {
+						e.Params = append(e.Params, &parameter{
+							Name:     name,
+							In:       "form",
+							Required: m.Schema.isRequired(name),
+							Schema:   m.Schema.Properties[name],
+						})
+					}
+				}
+			}
+			if res, ok := op.Responses["200"]; ok {
+				if m, ok := res.Content["application/json"]; ok {
+					e.Response = m.Schema
+				}
+			}
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// schemaNames returns the names of the component schemas, except the error
+// envelope that is declared by the client runtime.
+func (d *document) schemaNames() []string {
+	var names []string
+	for name := range d.Components.Schemas {
+		if name != "Error" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var initialisms = map[string]bool{
+	"api": true, "html": true, "http": true, "https": true, "id": true, "ip": true,
+	"json": true, "sql": true, "ttl": true, "uid": true, "uri": true, "url": true, "uuid": true,
+}
+
+// exportName converts the name to an exported Go identifier, like "user-id" to "UserID".
+func exportName(name string) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 && (unicode.IsLower(word[len(word)-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			flush()
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	var b strings.Builder
+	for _, w := range words {
+		if initialisms[strings.ToLower(w)] {
+			b.WriteString(strings.ToUpper(w))
+		} else {
+			r := []rune(w)
+			b.WriteString(strings.ToUpper(string(r[0])) + string(r[1:]))
+		}
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+}
+
+// pathSegments splits the path template to the static parts and the param names.
+func pathSegments(pathname string) (parts []string, params []string) {
+	for {
+		i := strings.IndexByte(pathname, '{')
+		j := strings.IndexByte(pathname, '}')
+		if i < 0 || j < i {
+			parts = append(parts, pathname)
+			return
+		}
+		parts = append(parts, pathname[:i])
+		params = append(params, pathname[i+1:j])
+		pathname = pathname[j+1:]
+	}
+}
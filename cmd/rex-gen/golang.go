@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const goRuntime = `
+// Error is the error envelope of the API, like {"error": {"status": 404, "message": "not found"}}.
+type Error struct {
+	Status  int    ` + "`json:\"status\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+// Client is the API client, the session cookies are kept by the cookie jar of the HTTPClient.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Header is sent with every request
+	Header http.Header
+}
+
+// New returns a new Client.
+func New(baseURL string) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Jar: jar},
+		Header:     http.Header{},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, header http.Header, body io.Reader, contentType string, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		data, _ := io.ReadAll(res.Body)
+		var envelope struct {
+			Error *Error ` + "`json:\"error\"`" + `
+		}
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error != nil {
+			return envelope.Error
+		}
+		message := strings.TrimSpace(string(data))
+		if message == "" {
+			message = http.StatusText(res.StatusCode)
+		}
+		return &Error{res.StatusCode, message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// setParam adds the param to the values, the maps are encoded as "key[sub]"
+// and the slices as repeated keys.
+func setParam(values url.Values, key string, v interface{}, required bool) {
+	rv := reflect.ValueOf(v)
+	if !required && (!rv.IsValid() || rv.IsZero()) {
+		return
+	}
+	addParam(values, key, rv)
+}
+
+func setHeader(header http.Header, key string, v interface{}, required bool) {
+	values := url.Values{}
+	setParam(values, key, v, required)
+	for _, value := range values[key] {
+		header.Add(key, value)
+	}
+}
+
+func addParam(values url.Values, key string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid:
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			addParam(values, key, v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			values.Add(key, string(v.Bytes()))
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			addParam(values, key, v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			addParam(values, key+"["+fmt.Sprint(k.Interface())+"]", v.MapIndex(k))
+		}
+	default:
+		if t, ok := v.Interface().(time.Time); ok {
+			values.Add(key, t.Format(time.RFC3339))
+			return
+		}
+		values.Add(key, fmt.Sprint(v.Interface()))
+	}
+}
+`
+
+type goGenerator struct {
+	doc *document
+	b   strings.Builder
+}
+
+func generateGo(doc *document, pkg string) string {
+	g := &goGenerator{doc: doc}
+	g.printf("// Code generated by rex-gen. DO NOT EDIT.\n\n")
+	if doc.Info.Title != "" {
+		g.printf("// Package %s is the client of %s %s.\n", pkg, doc.Info.Title, doc.Info.Version)
+	}
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n")
+	for _, name := range []string{"bytes", "context", "encoding/json", "fmt", "io", "net/http", "net/http/cookiejar", "net/url", "reflect", "strings", "time"} {
+		g.printf("\t%q\n", name)
+	}
+	g.printf(")\n\n")
+	g.printf("var _ = bytes.NewReader\n")
+	g.b.WriteString(goRuntime)
+
+	for _, name := range doc.schemaNames() {
+		s := doc.Components.Schemas[name]
+		g.printf("\n// %s is the %s schema.\ntype %s %s\n", exportName(name), name, exportName(name), g.typeOf(s, true))
+	}
+
+	for _, e := range doc.endpoints() {
+		g.endpoint(e)
+	}
+	return g.b.String()
+}
+
+func (g *goGenerator) printf(format string, v ...interface{}) {
+	fmt.Fprintf(&g.b, format, v...)
+}
+
+// typeOf returns the Go type of the schema.
+func (g *goGenerator) typeOf(s *schema, required bool) string {
+	if s == nil {
+		return "json.RawMessage"
+	}
+	if s.Ref != "" {
+		if required {
+			return exportName(s.refName())
+		}
+		return "*" + exportName(s.refName())
+	}
+	switch s.Type {
+	case "string":
+		switch {
+		case s.Format == "date-time":
+			return "time.Time"
+		case s.Format == "duration":
+			return "time.Duration"
+		case s.ContentEncoding == "base64":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		switch s.Format {
+		case "int8", "int16", "int32", "int64":
+			return s.Format
+		}
+		if s.Minimum != nil && *s.Minimum >= 0 {
+			return "uint64"
+		}
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.typeOf(s.Items, true)
+	case "object":
+		if len(s.Properties) == 0 {
+			if s.AdditionalProperties != nil {
+				return "map[string]" + g.typeOf(s.AdditionalProperties, true)
+			}
+			return "map[string]interface{}"
+		}
+		var b strings.Builder
+		b.WriteString("struct {\n")
+		for _, name := range s.propertyNames() {
+			required := s.isRequired(name)
+			tag := name
+			if !required {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:%s`\n", exportName(name), g.typeOf(s.Properties[name], required), strconv.Quote(tag))
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return "json.RawMessage"
+}
+
+func (g *goGenerator) endpoint(e *endpoint) {
+	name := exportName(e.Name)
+	paramsType := name + "Params"
+	if len(e.Params) > 0 {
+		g.printf("\n// %s is the params of %s.\ntype %s struct {\n", paramsType, name, paramsType)
+		for _, p := range e.Params {
+			if p.Required {
+				g.printf("\t// %s is required\n", exportName(p.Name))
+			}
+			g.printf("\t%s %s\n", exportName(p.Name), g.typeOf(p.Schema, true))
+		}
+		g.printf("}\n")
+	}
+
+	args := []string{"ctx context.Context"}
+	if len(e.Params) > 0 {
+		args = append(args, "params "+paramsType)
+	}
+	if e.Body != nil {
+		args = append(args, "body "+g.typeOf(e.Body, true))
+	}
+	resultType := ""
+	if e.Response != nil {
+		resultType = g.typeOf(e.Response, false)
+	}
+
+	g.printf("\n// %s calls `%s %s`.\n", name, e.Method, e.Path)
+	if e.Summary != "" {
+		g.printf("//\n// %s\n", e.Summary)
+	}
+	if e.Deprecated {
+		g.printf("//\n// Deprecated: the endpoint is deprecated.\n")
+	}
+	if resultType != "" {
+		g.printf("func (c *Client) %s(%s) (result %s, err error) {\n", name, strings.Join(args, ", "), resultType)
+	} else {
+		g.printf("func (c *Client) %s(%s) (err error) {\n", name, strings.Join(args, ", "))
+	}
+
+	hasForm := false
+	g.printf("\tquery := url.Values{}\n\theader := http.Header{}\n")
+	for _, p := range e.Params {
+		field := "params." + exportName(p.Name)
+		switch p.In {
+		case "query":
+			g.printf("\tsetParam(query, %q, %s, %v)\n", p.Name, field, p.Required)
+		case "header":
+			g.printf("\tsetHeader(header, %q, %s, %v)\n", p.Name, field, p.Required)
+		case "form":
+			hasForm = true
+		}
+	}
+
+	// the form params are sent in the query if the body is JSON
+	body, contentType := "nil", `""`
+	if e.Body != nil {
+		for _, p := range e.Params {
+			if p.In == "form" {
+				g.printf("\tsetParam(query, %q, %s, %v)\n", p.Name, "params."+exportName(p.Name), p.Required)
+			}
+		}
+		g.printf("\tdata, err := json.Marshal(body)\n\tif err != nil {\n\t\treturn\n\t}\n")
+		body, contentType = "bytes.NewReader(data)", `"application/json"`
+	} else if hasForm {
+		g.printf("\tform := url.Values{}\n")
+		for _, p := range e.Params {
+			if p.In == "form" {
+				g.printf("\tsetParam(form, %q, %s, %v)\n", p.Name, "params."+exportName(p.Name), p.Required)
+			}
+		}
+		body, contentType = "strings.NewReader(form.Encode())", `"application/x-www-form-urlencoded"`
+	}
+
+	parts, pathParams := pathSegments(e.Path)
+	var path []string
+	for i, part := range parts {
+		if part != "" {
+			path = append(path, strconv.Quote(part))
+		}
+		if i < len(pathParams) {
+			path = append(path, fmt.Sprintf("url.PathEscape(fmt.Sprint(params.%s))", exportName(pathParams[i])))
+		}
+	}
+
+	out := "nil"
+	if resultType != "" {
+		out = "&result"
+	}
+	g.printf("\terr = c.do(ctx, %q, %s, query, header, %s, %s, %s)\n", e.Method, strings.Join(path, " + "), body, contentType, out)
+	g.printf("\treturn\n}\n")
+}
@@ -0,0 +1,73 @@
+// Command rex-gen generates the typed clients of a rex API from the OpenAPI
+// document served by `APIHandler.OpenAPI`, the request and response types
+// are declared by the `Doc` option of the queries and mutations:
+//
+//	rex.Query("user/*", getUser).Doc(rex.EndpointDoc{Params: &UserParams{}, Response: User{}})
+//
+// Usage:
+//
+//	rex-gen -spec http://localhost:8080/openapi.json -ts ./web/api.ts -go ./client
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	spec := flag.String("spec", "", "the url or file path of the OpenAPI document")
+	tsOut := flag.String("ts", "", "the output file of the TypeScript client")
+	goOut := flag.String("go", "", "the output directory of the Go client package")
+	goPkg := flag.String("pkg", "", "the package name of the Go client, defaults to the base name of the output directory")
+	flag.Parse()
+
+	if *spec == "" || (*tsOut == "" && *goOut == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	doc, err := loadDocument(*spec)
+	if err != nil {
+		fail(err)
+	}
+
+	if *tsOut != "" {
+		if err := writeFile(*tsOut, []byte(generateTS(doc))); err != nil {
+			fail(err)
+		}
+	}
+
+	if *goOut != "" {
+		pkg := *goPkg
+		if pkg == "" {
+			abs, err := filepath.Abs(*goOut)
+			if err != nil {
+				fail(err)
+			}
+			pkg = filepath.Base(abs)
+		}
+		src := generateGo(doc, pkg)
+		code, err := format.Source([]byte(src))
+		if err != nil {
+			fail(fmt.Errorf("format go client: %v", err))
+		}
+		if err := writeFile(filepath.Join(*goOut, "client.go"), code); err != nil {
+			fail(err)
+		}
+	}
+}
+
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "rex-gen:", err)
+	os.Exit(1)
+}
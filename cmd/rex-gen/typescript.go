@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const tsRuntime = `
+/** The error envelope of the API, like {"error": {"status": 404, "message": "not found"}}. */
+export class APIError extends Error {
+  status: number
+  constructor(status: number, message: string) {
+    super(message)
+    this.name = "APIError"
+    this.status = status
+  }
+}
+
+export interface ClientOptions {
+  baseURL?: string
+  /** sent with every request */
+  headers?: Record<string, string>
+  fetch?: typeof fetch
+}
+
+type Params = Record<string, unknown>
+
+function addParam(values: URLSearchParams, key: string, value: unknown) {
+  if (value === undefined || value === null) {
+    return
+  }
+  if (Array.isArray(value)) {
+    value.forEach((v) => addParam(values, key, v))
+  } else if (value instanceof Date) {
+    values.append(key, value.toISOString())
+  } else if (typeof value === "object") {
+    Object.entries(value as Params).forEach(([k, v]) => addParam(values, key + "[" + k + "]", v))
+  } else {
+    values.append(key, String(value))
+  }
+}
+
+function createRequest(options: ClientOptions) {
+  const baseURL = (options.baseURL ?? "").replace(/\/+$/, "")
+  const fetchFn = options.fetch ?? globalThis.fetch.bind(globalThis)
+  // the session cookies for the runtimes without the cookie store of browsers
+  const cookies = new Map<string, string>()
+
+  return async function request<T>(method: string, path: string, query: URLSearchParams, headers: Record<string, string>, body?: BodyInit): Promise<T> {
+    const qs = query.toString()
+    const init: RequestInit = {
+      method,
+      body,
+      credentials: "include",
+      headers: { Accept: "application/json", ...options.headers, ...headers },
+    }
+    if (cookies.size > 0) {
+      (init.headers as Record<string, string>)["Cookie"] = Array.from(cookies, ([k, v]) => k + "=" + v).join("; ")
+    }
+    const res = await fetchFn(baseURL + path + (qs ? "?" + qs : ""), init)
+    const setCookies: string[] = typeof res.headers.getSetCookie === "function" ? res.headers.getSetCookie() : []
+    for (const cookie of setCookies) {
+      const pair = cookie.split(";")[0]
+      const i = pair.indexOf("=")
+      if (i > 0) {
+        const name = pair.slice(0, i).trim()
+        const value = pair.slice(i + 1).trim()
+        if (value === "" || /max-age=(0|-)/i.test(cookie)) {
+          cookies.delete(name)
+        } else {
+          cookies.set(name, value)
+        }
+      }
+    }
+    const text = await res.text()
+    let data: any = undefined
+    if (text && (res.headers.get("Content-Type") ?? "").includes("json")) {
+      data = JSON.parse(text)
+    }
+    if (!res.ok) {
+      throw new APIError(data?.error?.status ?? res.status, data?.error?.message ?? (text || res.statusText))
+    }
+    return (data ?? text) as T
+  }
+}
+`
+
+type tsGenerator struct {
+	doc *document
+	b   strings.Builder
+}
+
+func generateTS(doc *document) string {
+	g := &tsGenerator{doc: doc}
+	g.printf("// Code generated by rex-gen. DO NOT EDIT.\n")
+	if doc.Info.Title != "" {
+		g.printf("// The client of %s %s.\n", doc.Info.Title, doc.Info.Version)
+	}
+	for _, name := range doc.schemaNames() {
+		s := doc.Components.Schemas[name]
+		if s.Type == "object" && len(s.Properties) > 0 {
+			g.printf("\nexport interface %s %s\n", exportName(name), g.typeOf(s, ""))
+		} else {
+			g.printf("\nexport type %s = %s\n", exportName(name), g.typeOf(s, ""))
+		}
+	}
+	g.b.WriteString(tsRuntime)
+
+	g.printf("\n/** createClient returns the API client. */\n")
+	g.printf("export function createClient(options: ClientOptions = {}) {\n")
+	g.printf("  const request = createRequest(options)\n")
+	g.printf("  return {\n")
+	for _, e := range doc.endpoints() {
+		g.endpoint(e)
+	}
+	g.printf("  }\n}\n")
+	return g.b.String()
+}
+
+func (g *tsGenerator) printf(format string, v ...interface{}) {
+	fmt.Fprintf(&g.b, format, v...)
+}
+
+var tsIdentRegexp = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsKey(name string) string {
+	if tsIdentRegexp.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func tsAccess(object string, name string) string {
+	if tsIdentRegexp.MatchString(name) {
+		return object + "." + name
+	}
+	return object + "[" + strconv.Quote(name) + "]"
+}
+
+// typeOf returns the TypeScript type of the schema.
+func (g *tsGenerator) typeOf(s *schema, indent string) string {
+	if s == nil {
+		return "unknown"
+	}
+	if s.Ref != "" {
+		return exportName(s.refName())
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return g.typeOf(s.Items, indent) + "[]"
+	case "object":
+		if len(s.Properties) == 0 {
+			if s.AdditionalProperties != nil {
+				return "Record<string, " + g.typeOf(s.AdditionalProperties, indent) + ">"
+			}
+			return "Record<string, unknown>"
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, name := range s.propertyNames() {
+			optional := "?"
+			if s.isRequired(name) {
+				optional = ""
+			}
+			fmt.Fprintf(&b, "%s  %s%s: %s\n", indent, tsKey(name), optional, g.typeOf(s.Properties[name], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	}
+	return "unknown"
+}
+
+func (g *tsGenerator) endpoint(e *endpoint) {
+	name := e.Name
+	var args []string
+	if len(e.Params) > 0 {
+		var fields []string
+		for _, p := range e.Params {
+			optional := "?"
+			if p.Required || p.In == "path" {
+				optional = ""
+			}
+			fields = append(fields, fmt.Sprintf("%s%s: %s", tsKey(p.Name), optional, g.typeOf(p.Schema, "")))
+		}
+		arg := "params: { " + strings.Join(fields, "; ") + " }"
+		if !e.hasRequiredParam() {
+			arg += " = {}"
+		}
+		args = append(args, arg)
+	}
+	if e.Body != nil {
+		args = append(args, "body: "+g.typeOf(e.Body, "      "))
+	}
+	resultType := "unknown"
+	if e.Response != nil {
+		resultType = g.typeOf(e.Response, "      ")
+	}
+
+	g.printf("    /**\n     * `%s %s`", e.Method, e.Path)
+	if e.Summary != "" {
+		g.printf(" %s", e.Summary)
+	}
+	if e.Deprecated {
+		g.printf("\n     * @deprecated")
+	}
+	g.printf("\n     */\n")
+	g.printf("    %s(%s): Promise<%s> {\n", tsKey(name), strings.Join(args, ", "), resultType)
+	g.printf("      const query = new URLSearchParams()\n")
+	g.printf("      const headers: Record<string, string> = {}\n")
+
+	hasForm := false
+	for _, p := range e.Params {
+		switch p.In {
+		case "query":
+			g.printf("      addParam(query, %q, %s)\n", p.Name, tsAccess("params", p.Name))
+		case "header":
+			g.printf("      if (%s !== undefined) headers[%q] = String(%s)\n", tsAccess("params", p.Name), p.Name, tsAccess("params", p.Name))
+		case "form":
+			hasForm = true
+		}
+	}
+
+	// the form params are sent in the query if the body is JSON
+	body := ""
+	if e.Body != nil {
+		for _, p := range e.Params {
+			if p.In == "form" {
+				g.printf("      addParam(query, %q, %s)\n", p.Name, tsAccess("params", p.Name))
+			}
+		}
+		g.printf("      headers[\"Content-Type\"] = \"application/json\"\n")
+		body = ", JSON.stringify(body)"
+	} else if hasForm {
+		g.printf("      const form = new URLSearchParams()\n")
+		for _, p := range e.Params {
+			if p.In == "form" {
+				g.printf("      addParam(form, %q, %s)\n", p.Name, tsAccess("params", p.Name))
+			}
+		}
+		body = ", form"
+	}
+
+	parts, pathParams := pathSegments(e.Path)
+	var path strings.Builder
+	path.WriteString("`")
+	for i, part := range parts {
+		path.WriteString(strings.NewReplacer("`", "\\`", "$", "\\$").Replace(part))
+		if i < len(pathParams) {
+			fmt.Fprintf(&path, "${encodeURIComponent(String(%s))}", tsAccess("params", pathParams[i]))
+		}
+	}
+	path.WriteString("`")
+
+	g.printf("      return request<%s>(%q, %s, query, headers%s)\n", resultType, e.Method, path.String(), body)
+	g.printf("    },\n")
+}
@@ -0,0 +1,391 @@
+package rex
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionLevel is the level of the response compression.
+type CompressionLevel int
+
+const (
+	// CompressionBestSpeed is the fastest level, it's the default level
+	CompressionBestSpeed CompressionLevel = iota
+	CompressionDefault
+	CompressionBestSize
+)
+
+// CompressionConfig contains options for the Compression middleware.
+type CompressionConfig struct {
+	// Encodings are the supported encodings in order of preference, defaults to ["br", "zstd", "gzip"]
+	Encodings []string
+	Level     CompressionLevel
+	// MinSize is the min size of the compressed responses, defaults to 1024
+	MinSize int
+	// MIMETypes are the compressable content types, like "text/*" or "application/json"
+	MIMETypes []string
+}
+
+var defaultCompressionConfig = newCompressionConfig(CompressionConfig{})
+
+func newCompressionConfig(config CompressionConfig) *CompressionConfig {
+	if len(config.Encodings) == 0 {
+		config.Encodings = []string{"br", "zstd", "gzip"}
+	}
+	for _, encoding := range config.Encodings {
+		switch encoding {
+		case "br", "zstd", "gzip":
+		default:
+			panic(fmt.Sprintf("Compression: unsupported encoding '%s'", encoding))
+		}
+	}
+	if config.MinSize <= 0 {
+		config.MinSize = 1024
+	}
+	if len(config.MIMETypes) == 0 {
+		config.MIMETypes = []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"application/yaml",
+			"application/x-yaml",
+			"application/wasm",
+			"application/manifest+json",
+			"image/svg+xml",
+		}
+	}
+	return &config
+}
+
+// Compression returns a Compression middleware that configures the response
+// compression of the app.
+func Compression(config CompressionConfig) Handle {
+	c := newCompressionConfig(config)
+	return func(ctx *Context) interface{} {
+		ctx.compression = c
+		return nil
+	}
+}
+
+func (ctx *Context) compressionConfig() *CompressionConfig {
+	if ctx.compression != nil {
+		return ctx.compression
+	}
+	return defaultCompressionConfig
+}
+
+// compressable checks whether the content type is compressable.
+func (c *CompressionConfig) compressable(contentType string) bool {
+	mediaType, _ := splitMediaType(contentType)
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range c.MIMETypes {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(mediaType, t[:len(t)-1]) {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func splitMediaType(contentType string) (string, string) {
+	i := strings.IndexByte(contentType, ';')
+	if i < 0 {
+		return strings.ToLower(strings.TrimSpace(contentType)), ""
+	}
+	return strings.ToLower(strings.TrimSpace(contentType[:i])), contentType[i+1:]
+}
+
+// contentTypeByName returns the content type by the extension of the name.
+func contentTypeByName(name string) string {
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// compress enables the compression if the size is not less than the MinSize.
+func (ctx *Context) compress(size int64) {
+	if size >= int64(ctx.compressionConfig().MinSize) {
+		ctx.EnableCompression()
+	}
+}
+
+// EnableCompression enables the compression method based on the Accept-Encoding header,
+// the responses with the content type that is not compressable are skipped.
+func (ctx *Context) EnableCompression() {
+	w, ok := ctx.responseWriter()
+	if !ok || w.headerSent || w.compression != nil {
+		return
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+	config := ctx.compressionConfig()
+	if ctype := h.Get("Content-Type"); ctype != "" && !config.compressable(ctype) {
+		return
+	}
+	addVary(h, "Accept-Encoding")
+	encoding := acceptEncoding(ctx.R, config.Encodings)
+	if encoding == "" {
+		return
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", encoding)
+	if etag := h.Get("ETag"); etag != "" {
+		// the compressed response is a different representation
+		h.Set("ETag", encodedETag(etag, encoding))
+	}
+	w.compression = getCompressor(encoding, config.Level, w.rawWriter)
+}
+
+// addVary adds the header name to the Vary header if it's not present.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// parseAcceptEncoding parses the Accept-Encoding header to a map of the encoding to the q-value.
+func parseAcceptEncoding(header string) map[string]float64 {
+	weights := map[string]float64{}
+	for _, p := range strings.Split(header, ",") {
+		name, params := splitMediaType(p)
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				} else {
+					q = 0
+				}
+			}
+		}
+		weights[name] = q
+	}
+	return weights
+}
+
+// acceptEncoding returns the compression method based on the Accept-Encoding
+// header, the encoding with the highest q-value is chosen, and the order of the
+// encodings is used if the q-values are equal. An empty string is returned if
+// the client prefers the identity encoding.
+func acceptEncoding(r *http.Request, encodings []string) string {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" {
+		return ""
+	}
+	weights := parseAcceptEncoding(header)
+	var encoding string
+	var best float64
+	for _, name := range encodings {
+		q, ok := weights[name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > best {
+			encoding, best = name, q
+		}
+	}
+	if q, ok := weights["identity"]; ok && q > best {
+		return ""
+	}
+	return encoding
+}
+
+// acceptsEncoding checks the Accept-Encoding header whether accepts the encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	weights := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+	q, ok := weights[encoding]
+	if !ok {
+		q = weights["*"]
+	}
+	return q > 0
+}
+
+type resetWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledCompressor puts the compressor back to the pool after closing.
+type pooledCompressor struct {
+	resetWriteCloser
+	pool *sync.Pool
+}
+
+func (c *pooledCompressor) Close() error {
+	if c.resetWriteCloser == nil {
+		return nil
+	}
+	err := c.resetWriteCloser.Close()
+	c.resetWriteCloser.Reset(io.Discard)
+	c.pool.Put(c.resetWriteCloser)
+	c.resetWriteCloser = nil
+	return err
+}
+
+// dropCompression disables the compression that is enabled before writing.
+func (w *responseWriter) dropCompression() {
+	if c, ok := w.compression.(*pooledCompressor); ok {
+		c.Reset(io.Discard)
+		c.Close()
+	}
+	w.compression = nil
+}
+
+// compressorPools stores the pools of the compressors by the encoding and level
+var compressorPools sync.Map
+
+func getCompressor(encoding string, level CompressionLevel, w io.Writer) io.WriteCloser {
+	key := encoding + ":" + strconv.Itoa(int(level))
+	v, ok := compressorPools.Load(key)
+	if !ok {
+		v, _ = compressorPools.LoadOrStore(key, &sync.Pool{
+			New: func() interface{} {
+				return newCompressor(encoding, level)
+			},
+		})
+	}
+	pool := v.(*sync.Pool)
+	c := pool.Get().(resetWriteCloser)
+	c.Reset(w)
+	return &pooledCompressor{c, pool}
+}
+
+func newCompressor(encoding string, level CompressionLevel) resetWriteCloser {
+	switch encoding {
+	case "br":
+		l := brotli.BestSpeed
+		if level == CompressionDefault {
+			l = brotli.DefaultCompression
+		} else if level == CompressionBestSize {
+			l = brotli.BestCompression
+		}
+		return brotli.NewWriterLevel(io.Discard, l)
+	case "zstd":
+		l := zstd.SpeedFastest
+		if level == CompressionDefault {
+			l = zstd.SpeedDefault
+		} else if level == CompressionBestSize {
+			l = zstd.SpeedBestCompression
+		}
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(l), zstd.WithEncoderConcurrency(1))
+		return w
+	default:
+		l := gzip.BestSpeed
+		if level == CompressionDefault {
+			l = gzip.DefaultCompression
+		} else if level == CompressionBestSize {
+			l = gzip.BestCompression
+		}
+		w, _ := gzip.NewWriterLevel(io.Discard, l)
+		return w
+	}
+}
+
+// Decompress returns a Decompress middleware that decodes the request body
+// with the `Content-Encoding` header, like gzip, br, zstd and deflate. The
+// decoded body larger than the maxBytes is rejected with 413 to guard against
+// the decompression bombs, the maxBytes defaults to 32MB. The smaller limit
+// of the maxBytes and a previous BodyLimit middleware applies.
+func Decompress(maxBytes int64) Handle {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxMemory
+	}
+	return func(ctx *Context) interface{} {
+		header := ctx.R.Header.Get("Content-Encoding")
+		if header == "" || ctx.R.Body == nil || ctx.R.Body == http.NoBody {
+			return nil
+		}
+		var encodings []string
+		for _, p := range strings.Split(header, ",") {
+			if encoding := strings.ToLower(strings.TrimSpace(p)); encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+		body := &decodedBody{Reader: ctx.R.Body, closers: []io.Closer{ctx.R.Body}}
+		// the encodings are listed in the order in which they were applied
+		for i := len(encodings) - 1; i >= 0; i-- {
+			r, err := newDecompressor(encodings[i], body.Reader)
+			if err == errUnsupportedEncoding {
+				body.Close()
+				return &Error{http.StatusUnsupportedMediaType, "unsupported content encoding: " + encodings[i]}
+			}
+			if err != nil {
+				body.Close()
+				return &Error{400, "invalid " + encodings[i] + " body: " + err.Error()}
+			}
+			body.Reader = r
+			body.closers = append(body.closers, r)
+		}
+		limited := newLimitedBody(body, maxBytes, ctx.Form.body)
+		ctx.R.Body = limited
+		ctx.R.ContentLength = -1
+		ctx.R.Header.Del("Content-Encoding")
+		ctx.R.Header.Del("Content-Length")
+		ctx.Form.body = limited
+		return nil
+	}
+}
+
+var errUnsupportedEncoding = fmt.Errorf("unsupported encoding")
+
+func newDecompressor(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		// the window size is limited to 8MB as RFC 8878 recommends for HTTP
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "deflate":
+		return zlib.NewReader(r)
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// decodedBody closes the decompressors and the raw body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if e := b.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
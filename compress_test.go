@@ -0,0 +1,181 @@
+package rex
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		header    string
+		encodings []string
+		want      string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, deflate, br", want: "br"},
+		{header: "gzip, br;q=0.5", want: "gzip"},
+		{header: "zstd;q=0.8, gzip;q=0.8", want: "zstd"},
+		{header: "br;q=0, gzip", want: "gzip"},
+		{header: "*", want: "br"},
+		{header: "*;q=0.5, br;q=0", want: "zstd"},
+		{header: "identity", want: ""},
+		{header: "gzip;q=0.5, identity", want: ""},
+		{header: "gzip;q=invalid", want: ""},
+		{header: "GZIP", want: "gzip"},
+		{header: "br, gzip", encodings: []string{"gzip"}, want: "gzip"},
+	}
+	for _, test := range tests {
+		encodings := test.encodings
+		if encodings == nil {
+			encodings = defaultCompressionConfig.Encodings
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", test.header)
+		if got := acceptEncoding(r, encodings); got != test.want {
+			t.Errorf("%q %v: got %q, want %q", test.header, encodings, got, test.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	data := strings.Repeat("hello world ", 200)
+	api := &APIHandler{}
+	api.Use(Compression(CompressionConfig{MinSize: 100}))
+	api.Query("text", func(ctx *Context) interface{} {
+		return data
+	})
+	api.Query("small", func(ctx *Context) interface{} {
+		return "hello"
+	})
+	api.Query("image", func(ctx *Context) interface{} {
+		return Content("image.png", time.Time{}, strings.NewReader(data))
+	})
+
+	decode := map[string]func(r io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) { return r, nil },
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{target: "/text", acceptEncoding: "gzip", encoding: "gzip", body: data},
+		{target: "/text", acceptEncoding: "br", encoding: "br", body: data},
+		{target: "/text", acceptEncoding: "zstd", encoding: "zstd", body: data},
+		// the pooled compressors are reused
+		{target: "/text", acceptEncoding: "gzip", encoding: "gzip", body: data},
+		{target: "/text", acceptEncoding: "zstd", encoding: "zstd", body: data},
+		{target: "/text", acceptEncoding: "", encoding: "", body: data},
+		{target: "/small", acceptEncoding: "gzip", encoding: "", body: "hello"},
+		{target: "/image", acceptEncoding: "gzip", encoding: "", body: data},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s %q: got encoding %q, want %q", test.target, test.acceptEncoding, got, test.encoding)
+			continue
+		}
+		reader, err := decode[test.encoding](bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Errorf("%s %q: %v", test.target, test.acceptEncoding, err)
+			continue
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil || !strings.Contains(string(body), test.body) {
+			t.Errorf("%s %q: got body %.40q %v", test.target, test.acceptEncoding, body, err)
+		}
+	}
+}
+
+func TestResponseWriterCloseCompression(t *testing.T) {
+	w := &responseWriter{status: 200, rawWriter: httptest.NewRecorder()}
+	w.compression = getCompressor("gzip", CompressionBestSpeed, w.rawWriter)
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.compression != nil {
+		t.Fatal("the compressor is still referenced after it's put back to the pool")
+	}
+	// closing twice doesn't put the compressor back again
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressLimits(t *testing.T) {
+	gzipBody := func(data string) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte(data))
+		gw.Close()
+		return buf.Bytes()
+	}
+	// the bomb is about 10KB compressed and 10MB decompressed
+	bomb := gzipBody("a=" + strings.Repeat("0", 10<<20))
+	// the small is less than 512 bytes compressed and 64KB decompressed
+	small := gzipBody("a=" + strings.Repeat("0", 64<<10))
+
+	handle := func(ctx *Context) interface{} {
+		return len(ctx.Form.Value("a"))
+	}
+	api := &APIHandler{}
+	api.Mutation("decompress", Decompress(1<<20), handle)
+	api.Mutation("limit-decompress", BodyLimit(512), Decompress(1<<20), handle)
+	api.Mutation("decompress-limit", Decompress(1<<20), BodyLimit(32<<10), handle)
+	api.Mutation("large-limit", BodyLimit(1<<20), Decompress(128<<10), handle)
+
+	tests := []struct {
+		path   string
+		body   []byte
+		status int
+	}{
+		{path: "/decompress", body: small, status: 200},
+		{path: "/decompress", body: bomb, status: 413},
+		// the BodyLimit limits both the compressed and the decompressed body
+		{path: "/limit-decompress", body: bomb, status: 413},
+		{path: "/limit-decompress", body: small, status: 413},
+		{path: "/decompress-limit", body: small, status: 413},
+		{path: "/decompress-limit", body: bomb, status: 413},
+		{path: "/large-limit", body: small, status: 200},
+		{path: "/large-limit", body: bomb, status: 413},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.path, bytes.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %d bytes: got %d %.80s, want %d", test.path, len(test.body), w.Code, w.Body.String(), test.status)
+			continue
+		}
+		if test.status == 200 && strings.TrimSpace(w.Body.String()) != "65536" {
+			t.Errorf("%s %d bytes: got body %.80s", test.path, len(test.body), w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package rex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type rangeContent struct {
+	name  string
+	size  int64
+	mtime time.Time
+	open  func(offset int64, length int64) (io.ReadCloser, error)
+}
+
+// ContentFrom replies to the request with the content that is opened by the
+// range, like an object of the blob storage. It supports the single and
+// multiple byte ranges, the conditional requests and `If-Range` without
+// buffering the content.
+func ContentFrom(name string, size int64, mtime time.Time, open func(offset int64, length int64) (io.ReadCloser, error)) interface{} {
+	return &rangeContent{name, size, mtime, open}
+}
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// maxByteRanges is the max count of the ranges after coalescing, the full
+// content is served for more ranges.
+const maxByteRanges = 32
+
+// coalesceRanges sorts the ranges and merges the overlapping or adjacent ones.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	if len(ranges) <= 1 {
+		return ranges
+	}
+	sorted := append([]byteRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.start+last.length {
+			if end := r.start + r.length; end > last.start+last.length {
+				last.length = end - last.start
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// parseRange parses the Range header, the invalid header returns nil ranges.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, nil
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(header[6:], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.IndexByte(spec, '-')
+		if i < 0 {
+			return nil, nil
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		var r byteRange
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{size - n, n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = byteRange{start, end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// countingWriter counts the written bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func (ctx *Context) serveRangeContent(c *rangeContent) {
+	h := ctx.W.Header()
+	if h.Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(c.name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("Accept-Ranges", "bytes")
+	if !c.mtime.IsZero() {
+		h.Set("Last-Modified", c.mtime.UTC().Format(http.TimeFormat))
+		if h.Get("ETag") == "" {
+			h.Set("ETag", fmt.Sprintf(`"%x-%x"`, c.size, c.mtime.UnixNano()))
+		}
+	}
+	etag := h.Get("ETag")
+
+	// conditional requests(RFC 9110)
+	if im := ctx.R.Header.Get("If-Match"); im != "" {
+		if strings.TrimSpace(im) != "*" && !matchIfMatch(im, etag) {
+			ctx.W.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	} else if t, err := http.ParseTime(ctx.R.Header.Get("If-Unmodified-Since")); err == nil && !c.mtime.IsZero() && c.mtime.Truncate(time.Second).After(t) {
+		ctx.W.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	isGet := ctx.R.Method == "GET" || ctx.R.Method == "HEAD"
+	notModified := false
+	if inm := ctx.R.Header.Get("If-None-Match"); inm != "" {
+		notModified = isGet && matchETag(inm, etag)
+	} else if t, err := http.ParseTime(ctx.R.Header.Get("If-Modified-Since")); err == nil && isGet && !c.mtime.IsZero() {
+		notModified = !c.mtime.Truncate(time.Second).After(t)
+	}
+	if notModified {
+		h.Del("Content-Type")
+		ctx.W.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var ranges []byteRange
+	if header := ctx.R.Header.Get("Range"); header != "" && isGet && ctx.checkIfRange(c, etag) {
+		var err error
+		ranges, err = parseRange(header, c.size)
+		if err != nil {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", c.size))
+			ctx.ejson(&Error{http.StatusRequestedRangeNotSatisfiable, err.Error()})
+			return
+		}
+		ranges = coalesceRanges(ranges)
+		if len(ranges) > maxByteRanges {
+			ranges = nil
+		}
+	}
+
+	if len(ranges) <= 1 {
+		r, status := byteRange{0, c.size}, 200
+		if len(ranges) == 1 {
+			r, status = ranges[0], http.StatusPartialContent
+			h.Set("Content-Range", r.contentRange(c.size))
+		}
+		var rc io.ReadCloser
+		if ctx.R.Method != "HEAD" {
+			var err error
+			rc, err = c.open(r.start, r.length)
+			if err != nil {
+				h.Del("Content-Range")
+				ctx.ejson(&Error{500, err.Error()})
+				return
+			}
+			defer rc.Close()
+		}
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+		ctx.W.WriteHeader(status)
+		if rc != nil {
+			io.CopyN(ctx.W, rc, r.length)
+		}
+		return
+	}
+
+	ctype := h.Get("Content-Type")
+	partHeader := func(r byteRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Range": {r.contentRange(c.size)},
+			"Content-Type":  {ctype},
+		}
+	}
+	// multiple ranges, compute the length of the multipart body
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	for _, r := range ranges {
+		mw.CreatePart(partHeader(r))
+		counter += countingWriter(r.length)
+	}
+	mw.Close()
+
+	boundary := mw.Boundary()
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(int64(counter), 10))
+	ctx.W.WriteHeader(http.StatusPartialContent)
+	if ctx.R.Method == "HEAD" {
+		return
+	}
+	mw = multipart.NewWriter(ctx.W)
+	mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		if _, err := mw.CreatePart(partHeader(r)); err != nil {
+			return
+		}
+		if !ctx.copyRange(c, r) {
+			return
+		}
+	}
+	mw.Close()
+}
+
+// checkIfRange checks the If-Range header, the Range header is ignored if
+// the content is changed.
+func (ctx *Context) checkIfRange(c *rangeContent, etag string) bool {
+	ir := strings.TrimSpace(ctx.R.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return matchStrongETag(ir, etag)
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !c.mtime.IsZero() && !c.mtime.Truncate(time.Second).After(t)
+}
+
+// matchStrongETag checks the header whether matches the etag by the strong comparison.
+func matchStrongETag(header string, etag string) bool {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctx *Context) copyRange(c *rangeContent, r byteRange) bool {
+	rc, err := c.open(r.start, r.length)
+	if err != nil {
+		if ctx.logger != nil {
+			ctx.logger.Printf("[error] open content %s: %v", c.name, err)
+		}
+		return false
+	}
+	defer rc.Close()
+	_, err = io.CopyN(ctx.W, rc, r.length)
+	return err == nil
+}
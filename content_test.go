@@ -0,0 +1,115 @@
+package rex
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentFromRanges(t *testing.T) {
+	data := "0123456789"
+	api := &APIHandler{}
+	api.Query("file", func(ctx *Context) interface{} {
+		return ContentFrom("file.txt", int64(len(data)), time.Time{}, func(offset int64, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data[offset : offset+length])), nil
+		})
+	})
+
+	var many []string
+	for i := 0; i < 10; i += 2 {
+		many = append(many, fmt.Sprintf("%d-%d", i, i))
+	}
+	var tooMany []string
+	for i := 0; i <= maxByteRanges; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("%d-%d", i%10, i%10))
+	}
+
+	tests := []struct {
+		header string
+		status int
+		// parts are the content ranges and the bodies of the parts
+		parts []string
+		body  string
+	}{
+		{header: "", status: 200, body: data},
+		{header: "bytes=0-3", status: 206, parts: []string{"bytes 0-3/10 0123"}},
+		{header: "bytes=-3", status: 206, parts: []string{"bytes 7-9/10 789"}},
+		{header: "bytes=8-", status: 206, parts: []string{"bytes 8-9/10 89"}},
+		{header: "bytes=20-30", status: 416},
+		{header: "bytes=3-1", status: 200, body: data},
+		{header: "bytes=0-1,5-6", status: 206, parts: []string{"bytes 0-1/10 01", "bytes 5-6/10 56"}},
+		// the overlapping and adjacent ranges are coalesced
+		{header: "bytes=0-3,2-5", status: 206, parts: []string{"bytes 0-5/10 012345"}},
+		{header: "bytes=0-1,2-3,7-8", status: 206, parts: []string{"bytes 0-3/10 0123", "bytes 7-8/10 78"}},
+		{header: "bytes=5-6,0-1,1-2", status: 206, parts: []string{"bytes 0-2/10 012", "bytes 5-6/10 56"}},
+		{header: "bytes=0-9,0-9,0-9", status: 206, parts: []string{"bytes 0-9/10 0123456789"}},
+		{header: "bytes=" + strings.Join(many, ","), status: 206, parts: []string{
+			"bytes 0-0/10 0", "bytes 2-2/10 2", "bytes 4-4/10 4", "bytes 6-6/10 6", "bytes 8-8/10 8",
+		}},
+		// the ranges are coalesced before the count is checked
+		{header: "bytes=" + strings.Join(tooMany, ","), status: 206, parts: []string{"bytes 0-9/10 0123456789"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/file", nil)
+		if test.header != "" {
+			r.Header.Set("Range", test.header)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%q: got %d %s, want %d", test.header, w.Code, w.Body.String(), test.status)
+			continue
+		}
+		if test.status != 206 {
+			if w.Body.String() != test.body && test.body != "" {
+				t.Errorf("%q: got body %q, want %q", test.header, w.Body.String(), test.body)
+			}
+			continue
+		}
+		var parts []string
+		mediaType, params, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if mediaType == "multipart/byteranges" {
+			mr := multipart.NewReader(w.Body, params["boundary"])
+			for {
+				p, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				body, _ := io.ReadAll(p)
+				parts = append(parts, p.Header.Get("Content-Range")+" "+string(body))
+			}
+		} else {
+			parts = append(parts, w.Header().Get("Content-Range")+" "+w.Body.String())
+		}
+		if strings.Join(parts, "|") != strings.Join(test.parts, "|") {
+			t.Errorf("%q: got parts %q, want %q", test.header, parts, test.parts)
+		}
+	}
+}
+
+func TestCoalesceRangesLimit(t *testing.T) {
+	var ranges []string
+	for i := 0; i <= maxByteRanges; i++ {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", i*2, i*2))
+	}
+	size := (maxByteRanges + 1) * 2
+	api := &APIHandler{}
+	api.Query("file", func(ctx *Context) interface{} {
+		return ContentFrom("file.txt", int64(size), time.Time{}, func(offset int64, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(strings.Repeat("x", int(length)))), nil
+		})
+	})
+	r := httptest.NewRequest("GET", "/file", nil)
+	r.Header.Set("Range", "bytes="+strings.Join(ranges, ","))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	// too many ranges are served as the full content
+	if w.Code != 200 || w.Body.Len() != size {
+		t.Fatalf("got %d with %d bytes, want 200 with %d bytes", w.Code, w.Body.Len(), size)
+	}
+}
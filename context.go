@@ -2,36 +2,41 @@ package rex
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"os"
-	"path"
-	"strings"
 	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/ije/gox/utils"
 	"github.com/ije/rex/session"
 )
 
 // A Context to handle http requests.
 type Context struct {
-	W             http.ResponseWriter
-	R             *http.Request
-	Path          *Path
-	Form          *Form
-	Store         *Store
-	basicAuthUser string
-	acl           map[string]struct{}
-	aclUser       ACLUser
-	session       *Session
-	sessionPool   session.Pool
-	sidStore      session.SIDStore
-	logger        Logger
-	accessLogger  Logger
+	W              http.ResponseWriter
+	R              *http.Request
+	Path           *Path
+	Form           *Form
+	Store          *Store
+	basicAuthUser  string
+	remoteIP       string
+	acl            map[string]struct{}
+	aclUser        ACLUser
+	session        *Session
+	paging         *Paging
+	fields         *FieldSelection
+	fieldsParsed   bool
+	api            *APIHandler
+	templates      *Templates
+	compression    *CompressionConfig
+	chain          *handleChain
+	sessionPool    session.Pool
+	sidStore       session.SIDStore
+	logger         Logger
+	accessLogger   Logger
+	trustedProxies []*net.IPNet
 }
 
 // BasicAuthUser returns the BasicAuth username
@@ -118,54 +123,13 @@ func (ctx *Context) DeleteHeader(key string) {
 	ctx.W.Header().Del(key)
 }
 
-// RemoteIP returns the remote client IP.
+// RemoteIP returns the remote client IP, the forwarding headers(`Forwarded`,
+// `X-Forwarded-For` and `X-Real-IP`) are only accepted from trusted proxies.
 func (ctx *Context) RemoteIP() string {
-	ip := ctx.R.Header.Get("X-Real-IP")
-	if ip == "" {
-		ip = ctx.R.Header.Get("X-Forwarded-For")
-		if ip != "" {
-			ip, _ = utils.SplitByFirstByte(ip, ',')
-		} else {
-			ip = ctx.R.RemoteAddr
-		}
-	}
-	ip, _ = utils.SplitByLastByte(ip, ':')
-	return ip
-}
-
-// EnableCompression enables the compression method based on the Accept-Encoding header
-func (ctx *Context) EnableCompression() {
-	var encoding string
-	for _, p := range strings.Split(ctx.R.Header.Get("Accept-Encoding"), ",") {
-		name, _ := utils.SplitByFirstByte(p, ';')
-		switch strings.ToLower(strings.TrimSpace(name)) {
-		case "br":
-			encoding = "br"
-		case "gzip":
-			if encoding == "" {
-				encoding = "gzip"
-			}
-		}
-	}
-	if encoding != "" {
-		w, ok := ctx.W.(*responseWriter)
-		if ok && !w.headerSent {
-			h := w.Header()
-			if h.Get("Vary") == "" {
-				h.Set("Vary", "Accept-Encoding")
-			}
-			if h.Get("Content-Length") != "" {
-				h.Del("Content-Length")
-			}
-			h.Set("Content-Encoding", encoding)
-			switch encoding {
-			case "br":
-				w.compression = brotli.NewWriterLevel(w.rawWriter, brotli.BestSpeed)
-			case "gzip":
-				w.compression, _ = gzip.NewWriterLevel(w.rawWriter, gzip.BestSpeed)
-			}
-		}
+	if ctx.remoteIP == "" {
+		ctx.remoteIP = resolveRemoteIP(ctx.R, ctx.trustedProxies)
 	}
+	return ctx.remoteIP
 }
 
 func (ctx *Context) end(v interface{}, args ...int) {
@@ -182,9 +146,10 @@ func (ctx *Context) end(v interface{}, args ...int) {
 		if ctx.W.Header().Get("Content-Type") == "" {
 			ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
 		}
-		if len(r) > 1024 {
-			ctx.EnableCompression()
+		if ctx.notModified([]byte(r), status) {
+			return
 		}
+		ctx.compress(int64(len(r)))
 		if status >= 100 {
 			ctx.W.WriteHeader(status)
 		}
@@ -209,11 +174,6 @@ func (ctx *Context) end(v interface{}, args ...int) {
 		io.Copy(ctx.W, r)
 
 	case *contentful:
-		compressable := false
-		switch strings.TrimPrefix(path.Ext(r.name), ".") {
-		case "html", "htm", "xml", "svg", "css", "less", "sass", "scss", "json", "json5", "map", "js", "jsx", "mjs", "cjs", "ts", "tsx", "md", "mdx", "yaml", "txt", "wasm":
-			compressable = true
-		}
 		size, err := r.content.Seek(0, io.SeekEnd)
 		if err != nil {
 			ctx.ejson(&Error{500, err.Error()})
@@ -224,8 +184,13 @@ func (ctx *Context) end(v interface{}, args ...int) {
 			ctx.ejson(&Error{500, err.Error()})
 			return
 		}
-		if compressable && size > 1024 {
-			ctx.EnableCompression()
+		if ctx.W.Header().Get("Content-Type") == "" {
+			if ctype := contentTypeByName(r.name); ctype != "" {
+				ctx.SetHeader("Content-Type", ctype)
+			}
+		}
+		if ctx.W.Header().Get("Content-Type") != "" {
+			ctx.compress(size)
 		}
 		http.ServeContent(ctx.W, ctx.R, r.name, r.mtime, r.content)
 		c, ok := r.content.(io.Closer)
@@ -233,6 +198,27 @@ func (ctx *Context) end(v interface{}, args ...int) {
 			c.Close()
 		}
 
+	case *page:
+		ctx.renderPage(r, status)
+
+	case *cacheHit:
+		ctx.writeCacheHit(r)
+
+	case *rendering:
+		ctx.render(r, status)
+
+	case *rangeContent:
+		ctx.serveRangeContent(r)
+
+	case *proxying:
+		ctx.proxy(r)
+
+	case *handled:
+		// the response is written by the http.Handler
+
+	case tusStatus:
+		ctx.W.WriteHeader(int(r))
+
 	case *statusPlayload:
 		if status >= 100 {
 			ctx.end(r.payload, status)
@@ -240,26 +226,11 @@ func (ctx *Context) end(v interface{}, args ...int) {
 			ctx.end(r.payload, r.status)
 		}
 
-	case *fs:
-		filepath := path.Join(r.root, strings.Join(ctx.Path.segments, "/"))
-		fi, err := os.Stat(filepath)
-		if err == nil && fi.IsDir() {
-			filepath = path.Join(filepath, "index.html")
-			fi, err = os.Stat(filepath)
-		}
-		if err != nil && os.IsNotExist(err) && r.fallback != "" {
-			filepath = path.Join(r.root, utils.CleanPath(r.fallback))
-			fi, err = os.Stat(filepath)
-		}
-		if err != nil {
-			if os.IsNotExist(err) {
-				ctx.ejson(&Error{404, "not found"})
-			} else {
-				ctx.ejson(&Error{500, err.Error()})
-			}
-			return
-		}
-		ctx.end(File(filepath))
+	case *staticFS:
+		ctx.serveStatic(r)
+
+	case *Error:
+		ctx.ejson(r)
 
 	case error:
 		if status >= 100 {
@@ -279,16 +250,12 @@ func (ctx *Context) end(v interface{}, args ...int) {
 			return
 		}
 
-		switch e := r.(type) {
-		case *Error:
-			ctx.ejson(e)
-			return
-		case Error:
+		if e, ok := r.(Error); ok {
 			ctx.ejson(&e)
 			return
 		}
 
-		ctx.json(r, status)
+		ctx.json(ctx.selectFields(r), status)
 	}
 }
 
@@ -310,9 +277,10 @@ func (ctx *Context) json(v interface{}, status int) {
 		ctx.W.Write([]byte(`{"error":{"status":500,"message":"bad json"}}`))
 		return
 	}
-	if buf.Len() > 1024 {
-		ctx.EnableCompression()
+	if ctx.notModified(buf.Bytes(), status) {
+		return
 	}
+	ctx.compress(int64(buf.Len()))
 	if status >= 100 {
 		ctx.W.WriteHeader(status)
 	}
@@ -0,0 +1,377 @@
+package rex
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// a valueSource looks up values by key for the tag
+type valueSource struct {
+	tag    string
+	name   string
+	lookup func(key string) []string
+	keys   func() []string
+	// filled is the fields filled by the JSON body, they are skipped
+	filled map[string]bool
+}
+
+// Decode decodes the form values into the struct pointed to by v, by the `form`
+// tag of the fields:
+//
+//	type Params struct {
+//		Page   int               `form:"page" default:"1"`
+//		Tags   []string          `form:"tag"`            // ?tag=a&tag=b
+//		Filter map[string]string `form:"filter"`         // ?filter[status]=open
+//		Since  time.Time         `form:"since" layout:"2006-01-02"`
+//		Q      string            `form:"q,required"`
+//	}
+//
+// All the invalid values are reported by one 400 error.
+func (form *Form) Decode(v interface{}) error {
+	return decode(v, form.source())
+}
+
+// Decode decodes the path segments into the struct pointed to by v, by the
+// `path` tag of the fields that is the index of the segment.
+func (path *Path) Decode(v interface{}) error {
+	return decode(v, path.source())
+}
+
+// Decode decodes the form values, path segments and request headers into the
+// struct pointed to by v, by the `form`, `path` and `header` tags of the fields.
+func (ctx *Context) Decode(v interface{}) error {
+	return decode(v, ctx.Form.source(), ctx.Path.source(), headerSource(ctx.R.Header))
+}
+
+func (form *Form) source() valueSource {
+	form.parse()
+	return valueSource{
+		tag:  "form",
+		name: "form value",
+		lookup: func(key string) []string {
+			return form.R.Form[key]
+		},
+		keys: func() []string {
+			keys := make([]string, 0, len(form.R.Form))
+			for key := range form.R.Form {
+				keys = append(keys, key)
+			}
+			return keys
+		},
+	}
+}
+
+func (path *Path) source() valueSource {
+	return valueSource{
+		tag:  "path",
+		name: "path segment",
+		lookup: func(key string) []string {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(path.segments) {
+				return nil
+			}
+			return []string{path.segments[i]}
+		},
+		keys: func() []string { return nil },
+	}
+}
+
+func headerSource(header http.Header) valueSource {
+	return valueSource{
+		tag:  "header",
+		name: "header",
+		lookup: func(key string) []string {
+			return header.Values(key)
+		},
+		keys: func() []string {
+			keys := make([]string, 0, len(header))
+			for key := range header {
+				keys = append(keys, key)
+			}
+			return keys
+		},
+	}
+}
+
+type decodeErrors []string
+
+func (errs *decodeErrors) add(format string, v ...interface{}) {
+	for i, a := range v {
+		if e, ok := a.(*strconv.NumError); ok {
+			v[i] = e.Err
+		}
+	}
+	*errs = append(*errs, fmt.Sprintf(format, v...))
+}
+
+func decode(v interface{}, sources ...valueSource) error {
+	rv := reflect.ValueOf(v)
+	// decode through the pointers, like the `**Params`
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode: expected a struct pointer, got %T", v)
+	}
+	var errs decodeErrors
+	for _, src := range sources {
+		decodeStruct(rv.Elem(), src, "", &errs)
+	}
+	if len(errs) > 0 {
+		return &Error{400, strings.Join(errs, "; ")}
+	}
+	return nil
+}
+
+func decodeStruct(rv reflect.Value, src valueSource, prefix string, errs *decodeErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if prefix == "" && src.filled[field.Name] {
+			continue
+		}
+		fv := rv.Field(i)
+		tag, hasTag := field.Tag.Lookup(src.tag)
+		if !hasTag {
+			// embedded structs without tag share the prefix
+			if field.Anonymous && fv.Kind() == reflect.Struct {
+				decodeStruct(fv, src, prefix, errs)
+			}
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "[" + name + "]"
+		}
+		required := strings.Contains(","+opts+",", ",required,")
+		decodeField(fv, field, src, key, required, errs)
+	}
+}
+
+func decodeField(fv reflect.Value, field reflect.StructField, src valueSource, key string, required bool, errs *decodeErrors) {
+	ft := field.Type
+	isPtr := ft.Kind() == reflect.Ptr
+	if isPtr {
+		ft = ft.Elem()
+	}
+
+	// nested struct and map: `key[sub]=value`
+	if (ft.Kind() == reflect.Struct && ft != timeType && !reflect.PtrTo(ft).Implements(textUnmarshalerType)) || ft.Kind() == reflect.Map {
+		target := fv
+		if isPtr {
+			target = reflect.New(ft).Elem()
+		}
+		found := false
+		if ft.Kind() == reflect.Map {
+			found = decodeMap(target, src, key, errs)
+		} else {
+			for _, k := range src.keys() {
+				if strings.HasPrefix(k, key+"[") {
+					found = true
+					break
+				}
+			}
+			if found {
+				decodeStruct(target, src, key, errs)
+			} else {
+				// apply the defaults, the absent struct doesn't require the fields
+				decodeStruct(target, src, key, &decodeErrors{})
+			}
+		}
+		if found && isPtr {
+			fv.Set(target.Addr())
+		}
+		if !found && required {
+			errs.add("require %s '%s'", src.name, key)
+		}
+		return
+	}
+
+	values := src.lookup(key)
+	if len(values) == 0 && ft.Kind() == reflect.Slice {
+		values = src.lookup(key + "[]")
+	}
+	if len(values) == 0 || (len(values) == 1 && values[0] == "" && ft.Kind() != reflect.String) {
+		if def, ok := field.Tag.Lookup("default"); ok {
+			values = []string{def}
+			if ft.Kind() == reflect.Slice {
+				values = strings.Split(def, ",")
+			}
+		} else {
+			if required {
+				errs.add("require %s '%s'", src.name, key)
+			}
+			return
+		}
+	}
+
+	target := fv
+	if isPtr {
+		target = reflect.New(ft).Elem()
+	}
+	if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(ft, len(values), len(values))
+		for i, s := range values {
+			if err := setValue(slice.Index(i), s, field.Tag); err != nil {
+				errs.add("invalid %s '%s': %v", src.name, key, err)
+				return
+			}
+		}
+		target.Set(slice)
+	} else if err := setValue(target, values[0], field.Tag); err != nil {
+		errs.add("invalid %s '%s': %v", src.name, key, err)
+		return
+	}
+	if isPtr {
+		fv.Set(target.Addr())
+	}
+}
+
+func decodeMap(target reflect.Value, src valueSource, key string, errs *decodeErrors) bool {
+	mt := target.Type()
+	if mt.Key().Kind() != reflect.String {
+		errs.add("unsupported map key of %s '%s'", src.name, key)
+		return false
+	}
+	found := false
+next:
+	for _, k := range src.keys() {
+		if !strings.HasPrefix(k, key+"[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		sub := k[len(key)+1 : len(k)-1]
+		if strings.ContainsAny(sub, "[]") {
+			continue
+		}
+		values := src.lookup(k)
+		if len(values) == 0 {
+			continue
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMap(mt))
+		}
+		ev := reflect.New(mt.Elem()).Elem()
+		if mt.Elem().Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(mt.Elem(), len(values), len(values))
+			for i, s := range values {
+				if err := setValue(slice.Index(i), s, ""); err != nil {
+					// skip the field, don't store the partial slice
+					errs.add("invalid %s '%s': %v", src.name, k, err)
+					continue next
+				}
+			}
+			ev.Set(slice)
+		} else if err := setValue(ev, values[0], ""); err != nil {
+			errs.add("invalid %s '%s': %v", src.name, k, err)
+			continue
+		}
+		target.SetMapIndex(reflect.ValueOf(sub).Convert(mt.Key()), ev)
+		found = true
+	}
+	return found
+}
+
+func setValue(v reflect.Value, s string, tag reflect.StructTag) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), s, tag); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case timeType:
+		layout := tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "on", "yes":
+			v.SetBool(true)
+		case "off", "no":
+			v.SetBool(false)
+		default:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		fallthrough
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package rex
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decodeTestParams struct {
+	Page     int               `form:"page" default:"1"`
+	Size     *uint8            `form:"size"`
+	Q        string            `form:"q,required"`
+	Tags     []string          `form:"tag"`
+	IDs      []int             `form:"id" default:"1,2"`
+	Active   bool              `form:"active"`
+	Score    float64           `form:"score"`
+	Timeout  time.Duration     `form:"timeout"`
+	Since    time.Time         `form:"since" layout:"2006-01-02"`
+	IP       net.IP            `form:"ip"`
+	Filter   map[string]string `form:"filter"`
+	Ranges   map[string][]int  `form:"range"`
+	Owner    *decodeTestOwner  `form:"owner"`
+	Ignored  string            `form:"-"`
+	Untagged string
+	decodeTestEmbedded
+}
+
+type decodeTestOwner struct {
+	Name string `form:"name,required"`
+	Age  int    `form:"age"`
+}
+
+type decodeTestEmbedded struct {
+	Lang string `form:"lang" default:"en"`
+}
+
+func TestFormDecode(t *testing.T) {
+	size := uint8(10)
+	tests := []struct {
+		query string
+		want  decodeTestParams
+		err   []string
+	}{
+		{
+			query: "q=a",
+			want:  decodeTestParams{Page: 1, Q: "a", IDs: []int{1, 2}, decodeTestEmbedded: decodeTestEmbedded{"en"}},
+		},
+		{
+			query: "q=a&page=2&size=10&tag=x&tag=y&id[]=3&active=on&score=1.5&timeout=2s&since=2024-01-02&ip=10.0.0.1&lang=de&Ignored=x&Untagged=x",
+			want: decodeTestParams{
+				Page:               2,
+				Size:               &size,
+				Q:                  "a",
+				Tags:               []string{"x", "y"},
+				IDs:                []int{3},
+				Active:             true,
+				Score:              1.5,
+				Timeout:            2 * time.Second,
+				Since:              time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				IP:                 net.ParseIP("10.0.0.1"),
+				decodeTestEmbedded: decodeTestEmbedded{"de"},
+			},
+		},
+		{
+			// the empty value of the non-string field uses the default
+			query: "q=a&page=",
+			want:  decodeTestParams{Page: 1, Q: "a", IDs: []int{1, 2}, decodeTestEmbedded: decodeTestEmbedded{"en"}},
+		},
+		{
+			query: "q=a&filter[status]=open&filter[a][b]=x&range[x]=1&range[x]=2&owner[name]=bob&owner[age]=3",
+			want: decodeTestParams{
+				Page:               1,
+				Q:                  "a",
+				IDs:                []int{1, 2},
+				Filter:             map[string]string{"status": "open"},
+				Ranges:             map[string][]int{"x": {1, 2}},
+				Owner:              &decodeTestOwner{Name: "bob", Age: 3},
+				decodeTestEmbedded: decodeTestEmbedded{"en"},
+			},
+		},
+		{
+			// all the invalid values are reported
+			query: "page=x&size=256&id=1&id=x&active=maybe&since=2024&range[x]=y&owner[age]=3",
+			err: []string{
+				"invalid form value 'page'",
+				"invalid form value 'size'",
+				"require form value 'q'",
+				"invalid form value 'id'",
+				"invalid form value 'active'",
+				"invalid form value 'since'",
+				"invalid form value 'range[x]'",
+				"require form value 'owner[name]'",
+			},
+		},
+	}
+	for _, test := range tests {
+		form := &Form{R: httptest.NewRequest("GET", "/?"+test.query, nil)}
+		var params decodeTestParams
+		err := form.Decode(&params)
+		if len(test.err) > 0 {
+			e, ok := err.(*Error)
+			if !ok || e.Status != 400 {
+				t.Errorf("%q: got error %v, want 400", test.query, err)
+				continue
+			}
+			for _, msg := range test.err {
+				if !strings.Contains(e.Message, msg) {
+					t.Errorf("%q: got error %q, want %q", test.query, e.Message, msg)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.query, params, test.want)
+		}
+	}
+}
+
+func TestContextDecode(t *testing.T) {
+	type params struct {
+		ID    int    `path:"1"`
+		Name  string `form:"name"`
+		Token string `header:"X-Token,required"`
+	}
+	api := &APIHandler{}
+	api.Query("items/*", func(ctx *Context) interface{} {
+		var p params
+		if err := ctx.Decode(&p); err != nil {
+			return err
+		}
+		return p
+	})
+
+	tests := []struct {
+		target string
+		token  string
+		status int
+		body   string
+	}{
+		{target: "/items/5?name=a", token: "t", status: 200, body: `{"ID":5,"Name":"a","Token":"t"}`},
+		{target: "/items/5", status: 400},
+		{target: "/items/x", token: "t", status: 400},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.token != "" {
+			r.Header.Set("X-Token", test.token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status || (test.body != "" && strings.TrimSpace(w.Body.String()) != test.body) {
+			t.Errorf("%s: got %d %s, want %d %s", test.target, w.Code, w.Body.String(), test.status, test.body)
+		}
+	}
+
+	if err := decode(params{}, headerSource(nil)); err == nil {
+		t.Error("non-pointer: expected an error")
+	}
+}
@@ -21,11 +21,11 @@ func Use(middlewares ...Handle) {
 }
 
 // Query adds a query api
-func Query(endpoint string, handles ...Handle) {
-	defaultAPIHanlder.Query(endpoint, handles...)
+func Query(endpoint string, handles ...Handle) *Endpoint {
+	return defaultAPIHanlder.Query(endpoint, handles...)
 }
 
 // Mutation adds a mutation api
-func Mutation(endpoint string, handles ...Handle) {
-	defaultAPIHanlder.Mutation(endpoint, handles...)
+func Mutation(endpoint string, handles ...Handle) *Endpoint {
+	return defaultAPIHanlder.Mutation(endpoint, handles...)
 }
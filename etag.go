@@ -0,0 +1,139 @@
+package rex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// ETag returns the strong ETag of the JSON encoding of the value, the JSON
+// responses of the queries have the weak version of it. Set it as the ETag
+// header of the query to use the CheckIfMatch in the mutations:
+//
+//	ctx.SetHeader("ETag", rex.ETag(post))
+func ETag(v interface{}) string {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return ""
+	}
+	return strongETag(buf.Bytes())
+}
+
+func strongETag(data []byte) string {
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf(`"%x-%x"`, len(data), h.Sum64())
+}
+
+func weakETag(data []byte) string {
+	return "W/" + strongETag(data)
+}
+
+// encodedETag returns the strong ETag of the compressed response, like
+// `"etag-gzip"`, the weak ETag is not changed.
+func encodedETag(etag string, encoding string) string {
+	if strings.HasPrefix(etag, `"`) && len(etag) > 1 && strings.HasSuffix(etag, `"`) {
+		return etag[:len(etag)-1] + "-" + encoding + `"`
+	}
+	return etag
+}
+
+// trimETagEncoding trims the encoding suffix of the ETag of the compressed
+// response, the compressed response has the same resource state.
+func trimETagEncoding(tag string) string {
+	for _, encoding := range []string{"br", "zstd", "gzip"} {
+		if suffix := "-" + encoding + `"`; strings.HasSuffix(tag, suffix) {
+			return tag[:len(tag)-len(suffix)] + `"`
+		}
+	}
+	return tag
+}
+
+// matchETag checks the If-None-Match header whether matches the etag by the
+// weak comparison.
+func matchETag(header string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = trimETagEncoding(strings.TrimPrefix(etag, "W/"))
+	for _, tag := range strings.Split(header, ",") {
+		if trimETagEncoding(strings.TrimPrefix(strings.TrimSpace(tag), "W/")) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIfMatch checks the If-Match header whether matches the etag by the
+// strong comparison(RFC 9110 13.1.1), the weak ETags never match. The ETags of
+// the compressed responses match the etag without the encoding suffix.
+func matchIfMatch(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); strings.HasPrefix(tag, `"`) && (tag == etag || trimETagEncoding(tag) == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header of the query response data if it's not
+// set, and replies with 304 if the If-None-Match header matches the ETag.
+func (ctx *Context) notModified(data []byte, status int) bool {
+	if (status != 0 && status != 200) || (ctx.R.Method != "GET" && ctx.R.Method != "HEAD") {
+		return false
+	}
+	h := ctx.W.Header()
+	etag := h.Get("ETag")
+	if etag == "" {
+		etag = weakETag(data)
+		h.Set("ETag", etag)
+	}
+	if matchETag(ctx.R.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		ctx.W.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// CheckIfMatch checks the If-Match header of the request with the current
+// ETag of the resource, and replies with 412 if it doesn't match. The request
+// without the If-Match header is passed. The If-Match header is compared by the
+// strong comparison, the weak ETags never match, use the strong ETag like the
+// ETag function returns.
+//
+//	post := posts.Get(id)
+//	ctx.CheckIfMatch(rex.ETag(post))
+func (ctx *Context) CheckIfMatch(etag string) {
+	header := ctx.R.Header.Get("If-Match")
+	if header != "" && !matchIfMatch(header, etag) {
+		panic(&recoverError{http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed)})
+	}
+}
+
+// CacheControl returns a middleware that sets the Cache-Control header, the
+// zero maxAge(in seconds) sets `no-cache`.
+func CacheControl(maxAge int, public bool) Handle {
+	value := "no-cache"
+	if maxAge > 0 {
+		scope := "private"
+		if public {
+			scope = "public"
+		}
+		value = fmt.Sprintf("%s, max-age=%d", scope, maxAge)
+	}
+	return Header("Cache-Control", value)
+}
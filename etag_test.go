@@ -0,0 +1,132 @@
+package rex
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func etagTestServe(api *APIHandler, method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	post := map[string]string{"title": "hello"}
+	etag := ETag(post)
+	if strings.HasPrefix(etag, "W/") {
+		t.Fatalf("ETag should be strong, got %s", etag)
+	}
+	api := &APIHandler{}
+	api.Mutation("post", func(ctx *Context) interface{} {
+		ctx.CheckIfMatch(ETag(post))
+		return "ok"
+	})
+
+	tests := []struct {
+		ifMatch string
+		status  int
+	}{
+		{"", 200},
+		{etag, 200},
+		{`"other", ` + etag, 200},
+		{"*", 200},
+		{encodedETag(etag, "gzip"), 200},
+		{"W/" + etag, 412},
+		{`"other"`, 412},
+	}
+	for _, test := range tests {
+		w := etagTestServe(api, "POST", "/post", map[string]string{"If-Match": test.ifMatch})
+		if w.Code != test.status {
+			t.Errorf("If-Match %q: got %d %s, want %d", test.ifMatch, w.Code, w.Body.String(), test.status)
+		}
+		if w.Header().Get("ETag") != "" {
+			t.Errorf("If-Match %q: the mutation response has the ETag %s", test.ifMatch, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	post := map[string]string{"title": "hello"}
+	api := &APIHandler{}
+	api.Query("post", func(ctx *Context) interface{} {
+		return post
+	})
+	api.Query("big", func(ctx *Context) interface{} {
+		ctx.SetHeader("ETag", ETag(post))
+		return strings.Repeat("a", 2048)
+	})
+	weak := etagTestServe(api, "GET", "/post", nil).Header().Get("ETag")
+	if weak != "W/"+ETag(post) {
+		t.Fatalf("got ETag %q, want %q", weak, "W/"+ETag(post))
+	}
+	gzipped := etagTestServe(api, "GET", "/big", map[string]string{"Accept-Encoding": "gzip"}).Header().Get("ETag")
+	if gzipped != encodedETag(ETag(post), "gzip") {
+		t.Fatalf("got ETag %q of the compressed response", gzipped)
+	}
+
+	tests := []struct {
+		target      string
+		ifNoneMatch string
+		status      int
+	}{
+		{"/post", weak, 304},
+		{"/post", ETag(post), 304},
+		{"/post", `"other", ` + weak, 304},
+		{"/post", "*", 304},
+		{"/post", `W/"other"`, 200},
+		{"/big", gzipped, 304},
+		{"/big", ETag(post), 304},
+	}
+	for _, test := range tests {
+		w := etagTestServe(api, "GET", test.target, map[string]string{"If-None-Match": test.ifNoneMatch})
+		if w.Code != test.status {
+			t.Errorf("GET %s If-None-Match %q: got %d, want %d", test.target, test.ifNoneMatch, w.Code, test.status)
+		}
+	}
+}
+
+func TestContentFromConditional(t *testing.T) {
+	data := "0123456789"
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	etag := fmt.Sprintf(`"%x-%x"`, len(data), mtime.UnixNano())
+	api := &APIHandler{}
+	api.Query("file", func(ctx *Context) interface{} {
+		return ContentFrom("file.txt", int64(len(data)), mtime, func(offset int64, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data[offset : offset+length])), nil
+		})
+	})
+
+	tests := []struct {
+		header map[string]string
+		status int
+		body   string
+	}{
+		{map[string]string{"If-Match": etag}, 200, data},
+		{map[string]string{"If-Match": "*"}, 200, data},
+		{map[string]string{"If-Match": "W/" + etag}, 412, ""},
+		{map[string]string{"If-Match": `"other"`}, 412, ""},
+		{map[string]string{"If-None-Match": etag}, 304, ""},
+		{map[string]string{"If-None-Match": "W/" + etag}, 304, ""},
+		{map[string]string{"If-None-Match": `"other"`}, 200, data},
+		{map[string]string{"Range": "bytes=0-3", "If-Range": etag}, 206, "0123"},
+		{map[string]string{"Range": "bytes=0-3", "If-Range": "W/" + etag}, 200, data},
+		{map[string]string{"Range": "bytes=0-3", "If-Range": `"other"`}, 200, data},
+		{map[string]string{"Range": "bytes=0-3", "If-Range": mtime.Format("Mon, 02 Jan 2006 15:04:05 GMT")}, 206, "0123"},
+		{map[string]string{"Range": "bytes=0-3", "If-Range": mtime.Add(-time.Hour).Format("Mon, 02 Jan 2006 15:04:05 GMT")}, 200, data},
+	}
+	for _, test := range tests {
+		w := etagTestServe(api, "GET", "/file", test.header)
+		if w.Code != test.status || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%v: got %d %q, want %d %q", test.header, w.Code, w.Body.String(), test.status, test.body)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package rex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldSelection is the sparse fieldset of the `fields` query parameter,
+// like `fields=id,title,author(name,avatar)`. A nil selection selects all fields.
+type FieldSelection struct {
+	fields map[string]*FieldSelection
+}
+
+// Has checks the field whether is selected.
+func (s *FieldSelection) Has(name string) bool {
+	if s == nil {
+		return true
+	}
+	_, ok := s.fields[name]
+	return ok
+}
+
+// Sub returns the selection of the nested field, or nil if all sub fields are selected.
+func (s *FieldSelection) Sub(name string) *FieldSelection {
+	if s == nil {
+		return nil
+	}
+	return s.fields[name]
+}
+
+// Fields returns the selected field names.
+func (s *FieldSelection) Fields() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.fields))
+	for name := range s.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the selection in the `fields` syntax.
+func (s *FieldSelection) String() string {
+	if s == nil {
+		return ""
+	}
+	names := s.Fields()
+	for i, name := range names {
+		if sub := s.fields[name]; sub != nil {
+			names[i] = name + "(" + sub.String() + ")"
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFieldSelection parses the `fields` syntax, like `id,title,author(name,avatar)`.
+func ParseFieldSelection(spec string) (*FieldSelection, error) {
+	s, rest, err := parseFieldSelection(spec)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid fields: unexpected '%s'", rest)
+	}
+	return s, nil
+}
+
+func parseFieldSelection(spec string) (*FieldSelection, string, error) {
+	s := &FieldSelection{fields: map[string]*FieldSelection{}}
+	for {
+		i := strings.IndexAny(spec, ",()")
+		name := spec
+		if i >= 0 {
+			name = spec[:i]
+		}
+		name = strings.TrimSpace(name)
+		if i < 0 {
+			if name != "" {
+				s.fields[name] = nil
+			}
+			return s, "", nil
+		}
+		switch spec[i] {
+		case ',':
+			if name != "" {
+				s.fields[name] = nil
+			}
+			spec = spec[i+1:]
+		case '(':
+			if name == "" {
+				return nil, "", fmt.Errorf("invalid fields: missing name before '('")
+			}
+			sub, rest, err := parseFieldSelection(spec[i+1:])
+			if err != nil {
+				return nil, "", err
+			}
+			if !strings.HasPrefix(rest, ")") {
+				return nil, "", fmt.Errorf("invalid fields: missing ')'")
+			}
+			s.fields[name] = sub
+			spec = strings.TrimPrefix(strings.TrimSpace(rest[1:]), ",")
+			if spec == "" {
+				return s, "", nil
+			}
+		case ')':
+			if name != "" {
+				s.fields[name] = nil
+			}
+			return s, spec[i:], nil
+		}
+	}
+}
+
+// Fields returns the field selection of the `fields` query parameter, or nil
+// if all fields are requested. Handlers can use it to skip expensive joins.
+// The field selection only applies to the queries(GET and HEAD requests), the
+// responses of the mutations are not changed.
+func (ctx *Context) Fields() *FieldSelection {
+	if !ctx.fieldsParsed {
+		ctx.fieldsParsed = true
+		if ctx.R.Method != "GET" && ctx.R.Method != "HEAD" {
+			return nil
+		}
+		if spec := ctx.R.URL.Query().Get("fields"); spec != "" {
+			s, err := ParseFieldSelection(spec)
+			if err != nil {
+				panic(&recoverError{400, err.Error()})
+			}
+			if len(s.fields) > 0 {
+				ctx.fields = s
+			}
+		}
+	}
+	return ctx.fields
+}
+
+// selectFields applies the field selection to the value, the structs are
+// converted by their json tags.
+func (ctx *Context) selectFields(v interface{}) interface{} {
+	s := ctx.Fields()
+	if s == nil {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if decoder.Decode(&value) != nil {
+		return v
+	}
+	return applyFieldSelection(value, s)
+}
+
+func applyFieldSelection(v interface{}, s *FieldSelection) interface{} {
+	if s == nil {
+		return v
+	}
+	switch a := v.(type) {
+	case map[string]interface{}:
+		for key, value := range a {
+			if !s.Has(key) {
+				delete(a, key)
+			} else if sub := s.Sub(key); sub != nil {
+				a[key] = applyFieldSelection(value, sub)
+			}
+		}
+	case []interface{}:
+		for i, item := range a {
+			a[i] = applyFieldSelection(item, s)
+		}
+	}
+	return v
+}
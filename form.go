@@ -13,23 +13,34 @@ const (
 
 // A Form to handle request form data.
 type Form struct {
-	R *http.Request
+	R      *http.Request
+	limits UploadLimits
+	body   *limitedBody
+}
+
+func (form *Form) parse() {
+	if form.R.Form != nil {
+		return
+	}
+	maxMemory := form.limits.MaxMemory
+	if maxMemory <= 0 {
+		maxMemory = defaultMaxMemory
+	}
+	form.R.ParseMultipartForm(maxMemory)
+	if form.body.tooLarge() {
+		panic(&recoverError{http.StatusRequestEntityTooLarge, errBodyTooLarge.Error()})
+	}
 }
 
 // IsNil checks the value for the key whether is nil.
 func (form *Form) IsNil(key string) bool {
+	form.parse()
 	if form.R.Method == "POST" {
-		if form.R.PostForm == nil {
-			form.R.ParseMultipartForm(defaultMaxMemory)
-		}
 		_, ok := form.R.PostForm[key]
 		if ok {
 			return false
 		}
 	}
-	if form.R.Form == nil {
-		form.R.ParseMultipartForm(defaultMaxMemory)
-	}
 	_, ok := form.R.Form[key]
 	return !ok
 }
@@ -38,6 +49,7 @@ func (form *Form) IsNil(key string) bool {
 // PATCH, or PUT request body, or returns the first value for
 // the named component of the request url query.
 func (form *Form) Value(key string) string {
+	form.parse()
 	var value string
 	if form.R.Method == "POST" {
 		value = form.R.PostFormValue(key)
@@ -95,5 +107,33 @@ func (form *Form) RequireFloat(key string) float64 {
 
 // File returns the first file for the provided form key.
 func (form *Form) File(key string) (multipart.File, *multipart.FileHeader, error) {
-	return form.R.FormFile(key)
+	form.parse()
+	if form.R.MultipartForm == nil {
+		return nil, nil, http.ErrMissingFile
+	}
+	if max := form.limits.MaxFiles; max > 0 {
+		n := 0
+		for _, fhs := range form.R.MultipartForm.File {
+			n += len(fhs)
+		}
+		if n > max {
+			return nil, nil, errTooManyFiles
+		}
+	}
+	fhs := form.R.MultipartForm.File[key]
+	if len(fhs) == 0 {
+		return nil, nil, http.ErrMissingFile
+	}
+	fh := fhs[0]
+	if max := form.limits.MaxFileSize; max > 0 && fh.Size > max {
+		return nil, nil, errFileTooLarge
+	}
+	if !form.limits.isTypeAllowed(fh.Header.Get("Content-Type")) {
+		return nil, nil, errFileTypeNotAllowed
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, fh, nil
 }
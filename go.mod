@@ -1,9 +1,17 @@
 module github.com/ije/rex
 
-go 1.16
+go 1.18
 
 require (
 	github.com/andybalholm/brotli v1.0.0
 	github.com/ije/gox v0.5.6
+	github.com/klauspost/compress v1.17.2
+	github.com/oschwald/maxminddb-golang v1.10.0
 	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
 )
+
+require (
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.3.3 // indirect
+)
@@ -0,0 +1,134 @@
+package rex
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses the CIDR list, a single IP is treated as a /32 (or /128) network.
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.ContainsRune(s, '/') {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP '%s'", s)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				nets = append(nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s'", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddrIP parses the IP of addresses like "1.2.3.4", "1.2.3.4:80",
+// "2001:db8::1", "[2001:db8::1]" and "[2001:db8::1]:80".
+func parseAddrIP(addr string) net.IP {
+	addr = strings.Trim(strings.TrimSpace(addr), `"`)
+	if strings.HasPrefix(addr, "[") {
+		end := strings.IndexByte(addr, ']')
+		if end < 0 {
+			return nil
+		}
+		return net.ParseIP(addr[1:end])
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedChain returns the client addresses from the `Forwarded`(RFC 7239)
+// header, or from the `X-Forwarded-For` header if the former is absent.
+func forwardedChain(header http.Header) []string {
+	var chain []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val := splitKV(pair)
+					if strings.EqualFold(key, "for") {
+						chain = append(chain, val)
+					}
+				}
+			}
+		}
+		return chain
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			chain = append(chain, addr)
+		}
+	}
+	return chain
+}
+
+func splitKV(s string) (string, string) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return strings.TrimSpace(s), ""
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+}
+
+// resolveRemoteIP returns the client IP. The forwarding headers are only
+// accepted from trusted proxies, and the chain is walked from the right
+// to the first address that is not a trusted proxy.
+func resolveRemoteIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer := parseAddrIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !containsIP(trustedProxies, peer) {
+		return peer.String()
+	}
+
+	chain := forwardedChain(r.Header)
+	if len(chain) == 0 {
+		if ip := parseAddrIP(r.Header.Get("X-Real-IP")); ip != nil {
+			return ip.String()
+		}
+		return peer.String()
+	}
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseAddrIP(chain[i])
+		if ip == nil {
+			// obfuscated identifier("unknown", "_hidden") or garbage
+			break
+		}
+		client = ip
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+	return client.String()
+}
@@ -0,0 +1,100 @@
+package rex
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveRemoteIP(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8", "2001:db8::/32", "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{remoteAddr: "1.2.3.4:1234", want: "1.2.3.4"},
+		// the headers of the untrusted peer are ignored
+		{remoteAddr: "1.2.3.4:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, want: "1.2.3.4"},
+		{remoteAddr: "1.2.3.4:1234", header: map[string]string{"X-Real-IP": "5.6.7.8"}, want: "1.2.3.4"},
+		{remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Real-IP": "5.6.7.8"}, want: "5.6.7.8"},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, want: "5.6.7.8"},
+		// the chain is walked from the right to the first untrusted address
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 10.0.0.2"}, want: "5.6.7.8"},
+		{remoteAddr: "192.168.0.1:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8, 192.168.0.1"}, want: "5.6.7.8"},
+		{remoteAddr: "192.168.0.2:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, want: "192.168.0.2"},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "garbage, 10.0.0.2"}, want: "10.0.0.2"},
+		// the Forwarded header takes precedence over the X-Forwarded-For header
+		{
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"Forwarded": `for=5.6.7.8;proto=https, for="[2001:db8::1]:80"`, "X-Forwarded-For": "9.9.9.9"},
+			want:       "5.6.7.8",
+		},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"Forwarded": `for="[2001:db9::1]:80"`}, want: "2001:db9::1"},
+		{remoteAddr: "10.0.0.1:1234", header: map[string]string{"Forwarded": "for=unknown"}, want: "10.0.0.1"},
+		{remoteAddr: "[2001:db8::1]:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, want: "5.6.7.8"},
+		{remoteAddr: "[2001:db9::1]:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, want: "2001:db9::1"},
+		{remoteAddr: "invalid", want: "invalid"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for key, value := range test.header {
+			r.Header.Set(key, value)
+		}
+		if got := resolveRemoteIP(r, trusted); got != test.want {
+			t.Errorf("%s %v: got %s, want %s", test.remoteAddr, test.header, got, test.want)
+		}
+	}
+}
+
+func TestRemoteIPWithTrustedProxies(t *testing.T) {
+	api := &APIHandler{}
+	if err := api.SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	api.Query("ip", func(ctx *Context) interface{} {
+		return ctx.RemoteIP()
+	})
+	r := httptest.NewRequest("GET", "/ip", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if strings.TrimSpace(w.Body.String()) != "5.6.7.8" {
+		t.Fatalf("got %d %s, want 5.6.7.8", w.Code, w.Body.String())
+	}
+	if err := api.SetTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("invalid cidr: expected an error")
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+		ok   bool
+	}{
+		{cidr: "10.0.0.0/8", want: "10.0.0.0/8", ok: true},
+		{cidr: " 10.1.2.3/8 ", want: "10.0.0.0/8", ok: true},
+		{cidr: "10.0.0.1", want: "10.0.0.1/32", ok: true},
+		{cidr: "2001:db8::1", want: "2001:db8::1/128", ok: true},
+		{cidr: "10.0.0.0/33"},
+		{cidr: "10.0.0"},
+	}
+	for _, test := range tests {
+		nets, err := ParseCIDRs(test.cidr)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.cidr, err)
+			continue
+		}
+		if test.ok && (len(nets) != 1 || nets[0].String() != test.want) {
+			t.Errorf("%q: got %v, want %s", test.cidr, nets, test.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package rex
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// IPFilterRules contains the allow/deny lists of the IPFilter.
+type IPFilterRules struct {
+	// Allow is the CIDR list of allowed IPs, empty to allow all
+	Allow []string
+	// Deny is the CIDR list of denied IPs, it takes precedence over the Allow list
+	Deny []string
+	// AllowCountries is the ISO country code list of allowed countries, requires the GeoDB
+	AllowCountries []string
+	// DenyCountries is the ISO country code list of denied countries, requires the GeoDB
+	DenyCountries []string
+}
+
+// IPFilterConfig contains options for the IPFilter.
+type IPFilterConfig struct {
+	IPFilterRules
+	// Groups overrides the rules by group name, the empty lists of a group
+	// inherit the default rules
+	Groups map[string]IPFilterRules
+	// GeoDB is the path of a MaxMind-format(.mmdb) country database
+	GeoDB string
+}
+
+type ipFilterRules struct {
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	allowCountries map[string]struct{}
+	denyCountries  map[string]struct{}
+}
+
+// IPFilter filters the requests by the client IP.
+type IPFilter struct {
+	lock   sync.RWMutex
+	rules  *ipFilterRules
+	groups map[string]*ipFilterRules
+	geoDB  *maxminddb.Reader
+}
+
+// NewIPFilter returns a new IPFilter.
+func NewIPFilter(config IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{}
+	err := f.Reload(config)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload replaces the rules of the IPFilter at runtime, the GeoDB is reopened
+// to pick up the replaced database file.
+func (f *IPFilter) Reload(config IPFilterConfig) error {
+	rules, err := parseIPFilterRules(config.IPFilterRules, nil)
+	if err != nil {
+		return err
+	}
+	if rules.hasCountries() && config.GeoDB == "" {
+		return fmt.Errorf("IPFilter: the country rules require the GeoDB")
+	}
+	groups := map[string]*ipFilterRules{}
+	for name, g := range config.Groups {
+		groups[name], err = parseIPFilterRules(g, rules)
+		if err != nil {
+			return fmt.Errorf("group '%s': %v", name, err)
+		}
+		if groups[name].hasCountries() && config.GeoDB == "" {
+			return fmt.Errorf("IPFilter: the country rules of group '%s' require the GeoDB", name)
+		}
+	}
+
+	// the geo database is always reopened, the file may be replaced at the same path
+	var geoDB *maxminddb.Reader
+	if config.GeoDB != "" {
+		geoDB, err = maxminddb.Open(config.GeoDB)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.lock.Lock()
+	oldGeoDB := f.geoDB
+	f.rules = rules
+	f.groups = groups
+	f.geoDB = geoDB
+	f.lock.Unlock()
+
+	if oldGeoDB != nil {
+		oldGeoDB.Close()
+	}
+	return nil
+}
+
+// Handle is the IPFilter middleware with the default rules.
+func (f *IPFilter) Handle(ctx *Context) interface{} {
+	f.lock.RLock()
+	rules := f.rules
+	f.lock.RUnlock()
+	return f.check(ctx, rules)
+}
+
+// Group returns a middleware with the rules of the group.
+func (f *IPFilter) Group(name string) Handle {
+	return func(ctx *Context) interface{} {
+		f.lock.RLock()
+		rules, ok := f.groups[name]
+		if !ok {
+			rules = f.rules
+		}
+		f.lock.RUnlock()
+		return f.check(ctx, rules)
+	}
+}
+
+func (f *IPFilter) check(ctx *Context, rules *ipFilterRules) interface{} {
+	ip := net.ParseIP(ctx.RemoteIP())
+	if ip == nil {
+		return &Error{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
+	}
+	if containsIP(rules.deny, ip) {
+		return &Error{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
+	}
+	if len(rules.allow) > 0 && !containsIP(rules.allow, ip) {
+		return &Error{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
+	}
+	if rules.hasCountries() {
+		country, err := f.lookupCountry(ip)
+		if err != nil {
+			if ctx.logger != nil {
+				ctx.logger.Printf("[error] IPFilter: %v", err)
+			}
+			return &Error{500, http.StatusText(500)}
+		}
+		if _, ok := rules.denyCountries[country]; ok {
+			return &Error{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
+		}
+		if _, ok := rules.allowCountries[country]; len(rules.allowCountries) > 0 && !ok {
+			return &Error{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
+		}
+	}
+	return nil
+}
+
+func (f *IPFilter) lookupCountry(ip net.IP) (string, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if f.geoDB == nil {
+		return "", fmt.Errorf("missing geo database")
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	err := f.geoDB.Lookup(ip, &record)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(record.Country.ISOCode), nil
+}
+
+func (rules *ipFilterRules) hasCountries() bool {
+	return len(rules.allowCountries) > 0 || len(rules.denyCountries) > 0
+}
+
+func parseIPFilterRules(r IPFilterRules, inherit *ipFilterRules) (rules *ipFilterRules, err error) {
+	rules = &ipFilterRules{}
+	if inherit != nil {
+		*rules = *inherit
+	}
+	if len(r.Allow) > 0 {
+		rules.allow, err = ParseCIDRs(r.Allow...)
+		if err != nil {
+			return
+		}
+	}
+	if len(r.Deny) > 0 {
+		rules.deny, err = ParseCIDRs(r.Deny...)
+		if err != nil {
+			return
+		}
+	}
+	if len(r.AllowCountries) > 0 {
+		rules.allowCountries = toCountrySet(r.AllowCountries)
+	}
+	if len(r.DenyCountries) > 0 {
+		rules.denyCountries = toCountrySet(r.DenyCountries)
+	}
+	return
+}
+
+func toCountrySet(codes []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" {
+			set[code] = struct{}{}
+		}
+	}
+	return set
+}
@@ -0,0 +1,86 @@
+package rex
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(IPFilterConfig{
+		IPFilterRules: IPFilterRules{
+			Allow: []string{"10.0.0.0/8"},
+			Deny:  []string{"10.0.1.0/24"},
+		},
+		Groups: map[string]IPFilterRules{
+			// inherits the allow list
+			"admin": {Deny: []string{"10.0.2.0/24"}},
+			// inherits the deny list
+			"public": {Allow: []string{"0.0.0.0/0", "::/0"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &APIHandler{}
+	api.Query("default", f.Handle, func(ctx *Context) interface{} { return "ok" })
+	api.Query("admin", f.Group("admin"), func(ctx *Context) interface{} { return "ok" })
+	api.Query("public", f.Group("public"), func(ctx *Context) interface{} { return "ok" })
+	api.Query("unknown", f.Group("unknown"), func(ctx *Context) interface{} { return "ok" })
+
+	tests := []struct {
+		path   string
+		ip     string
+		status int
+	}{
+		{"/default", "10.0.0.1", 200},
+		{"/default", "192.168.0.1", 403},
+		// the deny list takes precedence over the allow list
+		{"/default", "10.0.1.1", 403},
+		{"/default", "10.0.2.1", 200},
+		{"/admin", "10.0.1.1", 200},
+		{"/admin", "10.0.2.1", 403},
+		{"/admin", "192.168.0.1", 403},
+		{"/public", "192.168.0.1", 200},
+		{"/public", "2001:db8::1", 200},
+		{"/public", "10.0.1.1", 403},
+		// the unknown group uses the default rules
+		{"/unknown", "10.0.1.1", 403},
+		{"/unknown", "10.0.0.1", 200},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = test.ip + ":1234"
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s from %s: got %d %s, want %d", test.path, test.ip, w.Code, w.Body.String(), test.status)
+		}
+	}
+}
+
+func TestIPFilterConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config IPFilterConfig
+	}{
+		{"invalid cidr", IPFilterConfig{IPFilterRules: IPFilterRules{Allow: []string{"10.0.0.0/33"}}}},
+		{"invalid group cidr", IPFilterConfig{Groups: map[string]IPFilterRules{"admin": {Deny: []string{"invalid"}}}}},
+		{"allow countries without geo db", IPFilterConfig{IPFilterRules: IPFilterRules{AllowCountries: []string{"US"}}}},
+		{"deny countries without geo db", IPFilterConfig{IPFilterRules: IPFilterRules{DenyCountries: []string{"US"}}}},
+		{"group countries without geo db", IPFilterConfig{Groups: map[string]IPFilterRules{"admin": {AllowCountries: []string{"US"}}}}},
+		{"missing geo db", IPFilterConfig{IPFilterRules: IPFilterRules{DenyCountries: []string{"US"}}, GeoDB: "testdata/missing.mmdb"}},
+	}
+	for _, test := range tests {
+		if _, err := NewIPFilter(test.config); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	f, err := NewIPFilter(IPFilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(IPFilterConfig{IPFilterRules: IPFilterRules{DenyCountries: []string{"US"}}}); err == nil {
+		t.Error("reload: expected an error")
+	}
+}
@@ -0,0 +1,549 @@
+package rex
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/rex/session"
+)
+
+const (
+	oidcFlowKey     = "oidc.flow"
+	oidcIdentityKey = "oidc.identity"
+)
+
+// OIDCConfig contains options for the OpenID Connect login flow.
+type OIDCConfig struct {
+	// Issuer is the identity provider URL, like "https://accounts.example.com"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback endpoint
+	RedirectURL string
+	// Scopes defaults to ["openid", "profile", "email"]
+	Scopes []string
+	// ResponseMode can be "query"(default) or "form_post"
+	ResponseMode string
+	// LoginEndpoint defaults to "login"
+	LoginEndpoint string
+	// CallbackEndpoint defaults to "oauth/callback"
+	CallbackEndpoint string
+	// LogoutEndpoint defaults to "logout"
+	LogoutEndpoint string
+	// PermissionsClaim is the claim to fill the ACLUser permissions, defaults to "groups"
+	PermissionsClaim string
+	// Permissions returns the permissions of the identity, overrides the PermissionsClaim
+	Permissions func(claims map[string]interface{}) []string
+	// HTTPClient is used to talk to the identity provider, defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// OIDCUser is the identity from the ID token, it implements the ACLUser interface.
+type OIDCUser struct {
+	Subject       string                 `json:"sub"`
+	Name          string                 `json:"name,omitempty"`
+	Email         string                 `json:"email,omitempty"`
+	Claims        map[string]interface{} `json:"claims"`
+	PermissionIDs []string               `json:"permissions"`
+}
+
+// Permissions returns the permission IDs of the user
+func (u *OIDCUser) Permissions() []string {
+	return u.PermissionIDs
+}
+
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+	Expires  int64  `json:"expires"`
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcRP struct {
+	config   OIDCConfig
+	lock     sync.RWMutex
+	provider *oidcProvider
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+}
+
+// OIDC registers the login, callback and logout mutations of the OpenID Connect
+// authorization code flow(with PKCE) to the APIHandler, and adds a middleware
+// that fills the ACLUser by the identity stored in the session.
+func (a *APIHandler) OIDC(config OIDCConfig) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		panic("OIDC: missing issuer, client id or redirect url")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.LoginEndpoint == "" {
+		config.LoginEndpoint = "login"
+	}
+	if config.CallbackEndpoint == "" {
+		config.CallbackEndpoint = "oauth/callback"
+	}
+	if config.LogoutEndpoint == "" {
+		config.LogoutEndpoint = "logout"
+	}
+	if config.PermissionsClaim == "" {
+		config.PermissionsClaim = "groups"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	rp := &oidcRP{config: config}
+	a.Use(rp.identify)
+	a.Mutation(config.LoginEndpoint, rp.login)
+	a.Mutation(config.LogoutEndpoint, rp.logout)
+	if config.ResponseMode == "form_post" {
+		a.Mutation(config.CallbackEndpoint, rp.callback)
+	} else {
+		a.Query(config.CallbackEndpoint, rp.callback)
+	}
+}
+
+// OIDC registers the OpenID Connect login flow to the default APIHandler.
+func OIDC(config OIDCConfig) {
+	defaultAPIHanlder.OIDC(config)
+}
+
+func (rp *oidcRP) identify(ctx *Context) interface{} {
+	// do not create sessions for anonymous requests
+	if ctx.sidStore.Get(ctx.R) == "" {
+		return nil
+	}
+	data := ctx.Session().Get(oidcIdentityKey)
+	if len(data) > 0 {
+		var user OIDCUser
+		if json.Unmarshal(data, &user) == nil {
+			ctx.SetACLUser(&user)
+		}
+	}
+	return nil
+}
+
+// useRootCookie sets the session cookie at the root path, the session of the
+// login flow is shared by the callback and the app.
+func useRootCookie(ctx *Context) {
+	if s, ok := ctx.sidStore.(*session.CookieSIDStore); ok && s.Path == "" {
+		ctx.sidStore = &session.CookieSIDStore{CookieName: s.CookieName, Path: "/"}
+	}
+}
+
+func (rp *oidcRP) login(ctx *Context) interface{} {
+	useRootCookie(ctx)
+	provider, err := rp.getProvider()
+	if err != nil {
+		return &Error{502, err.Error()}
+	}
+
+	flow := oidcFlow{
+		State:    rs.Base64.String(32),
+		Nonce:    rs.Base64.String(32),
+		Verifier: rs.Base64.String(64),
+		Redirect: "/",
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	// only allow local redirects
+	if r := ctx.Form.Value("redirect"); strings.HasPrefix(r, "/") && !strings.HasPrefix(r, "//") && !strings.HasPrefix(r, "/\\") {
+		flow.Redirect = r
+	}
+	data, _ := json.Marshal(flow)
+	ctx.Session().Set(oidcFlowKey, data)
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.config.ClientID},
+		"redirect_uri":          {rp.config.RedirectURL},
+		"scope":                 {strings.Join(rp.config.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if rp.config.ResponseMode != "" {
+		query.Set("response_mode", rp.config.ResponseMode)
+	}
+	authURL := provider.AuthorizationEndpoint
+	if strings.ContainsRune(authURL, '?') {
+		authURL += "&" + query.Encode()
+	} else {
+		authURL += "?" + query.Encode()
+	}
+	return Redirect(authURL, http.StatusSeeOther)
+}
+
+func (rp *oidcRP) callback(ctx *Context) interface{} {
+	useRootCookie(ctx)
+	data := ctx.Session().Get(oidcFlowKey)
+	if len(data) == 0 {
+		return &Error{400, "missing login flow"}
+	}
+	ctx.Session().Delete(oidcFlowKey)
+
+	var flow oidcFlow
+	if json.Unmarshal(data, &flow) != nil || time.Now().Unix() > flow.Expires {
+		return &Error{400, "invalid login flow"}
+	}
+	if ctx.Form.Value("state") != flow.State {
+		return &Error{400, "invalid state"}
+	}
+	if e := ctx.Form.Value("error"); e != "" {
+		if desc := ctx.Form.Value("error_description"); desc != "" {
+			e += ": " + desc
+		}
+		return &Error{401, e}
+	}
+	code := ctx.Form.Require("code")
+
+	provider, err := rp.getProvider()
+	if err != nil {
+		return &Error{502, err.Error()}
+	}
+	idToken, err := rp.exchange(provider, code, flow.Verifier)
+	if err != nil {
+		return &Error{502, err.Error()}
+	}
+	claims, err := rp.verify(idToken, flow.Nonce)
+	if err != nil {
+		return &Error{401, err.Error()}
+	}
+
+	user := &OIDCUser{Claims: claims}
+	user.Subject, _ = claims["sub"].(string)
+	user.Name, _ = claims["name"].(string)
+	user.Email, _ = claims["email"].(string)
+	if rp.config.Permissions != nil {
+		user.PermissionIDs = rp.config.Permissions(claims)
+	} else if list, ok := claims[rp.config.PermissionsClaim].([]interface{}); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				user.PermissionIDs = append(user.PermissionIDs, s)
+			}
+		}
+	}
+	// renew the session to prevent session fixation
+	ctx.sessionPool.Destroy(ctx.Session().SID())
+	ctx.session = nil
+	data, _ = json.Marshal(user)
+	ctx.Session().Set(oidcIdentityKey, data)
+	ctx.SetACLUser(user)
+	return Redirect(flow.Redirect, http.StatusSeeOther)
+}
+
+func (rp *oidcRP) logout(ctx *Context) interface{} {
+	useRootCookie(ctx)
+	if sid := ctx.sidStore.Get(ctx.R); sid != "" {
+		// destroy the session and renew the sid, the old sid is useless after logout
+		if err := ctx.sessionPool.Destroy(sid); err != nil {
+			return &Error{500, err.Error()}
+		}
+		ctx.session = nil
+		ctx.aclUser = nil
+		ctx.Session()
+	}
+	return Redirect("/", http.StatusSeeOther)
+}
+
+func (rp *oidcRP) getProvider() (*oidcProvider, error) {
+	rp.lock.RLock()
+	provider := rp.provider
+	rp.lock.RUnlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	var p oidcProvider
+	err := rp.getJSON(strings.TrimSuffix(rp.config.Issuer, "/")+"/.well-known/openid-configuration", &p)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %v", err)
+	}
+	if p.Issuer != rp.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery: issuer mismatch %q", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery: missing endpoints")
+	}
+
+	rp.lock.Lock()
+	rp.provider = &p
+	rp.lock.Unlock()
+	return &p, nil
+}
+
+func (rp *oidcRP) exchange(provider *oidcProvider, code string, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if rp.config.ClientSecret == "" {
+		form.Set("client_id", rp.config.ClientID)
+	}
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.config.ClientID), url.QueryEscape(rp.config.ClientSecret))
+	}
+	res, err := rp.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var ret struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&ret)
+	if err != nil {
+		return "", fmt.Errorf("OIDC token: %v", err)
+	}
+	if ret.Error != "" {
+		return "", fmt.Errorf("OIDC token: %s %s", ret.Error, ret.ErrorDescription)
+	}
+	if res.StatusCode != 200 || ret.IDToken == "" {
+		return "", fmt.Errorf("OIDC token: missing id token(%d)", res.StatusCode)
+	}
+	return ret.IDToken, nil
+}
+
+func (rp *oidcRP) verify(token string, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token signature")
+	}
+	key, err := rp.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != rp.config.Issuer {
+		return nil, errors.New("invalid id token issuer")
+	}
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == rp.config.ClientID
+	case []interface{}:
+		for _, v := range aud {
+			if v == rp.config.ClientID {
+				audOK = true
+				break
+			}
+		}
+		if azp, ok := claims["azp"].(string); ok && azp != rp.config.ClientID {
+			audOK = false
+		}
+	}
+	if !audOK {
+		return nil, errors.New("invalid id token audience")
+	}
+	const leeway = 60
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now > exp+leeway {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && iat > now+leeway {
+		return nil, errors.New("id token issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("invalid id token nonce")
+	}
+	return claims, nil
+}
+
+func (rp *oidcRP) getKey(kid string) (crypto.PublicKey, error) {
+	rp.lock.RLock()
+	key, ok := rp.keys[kid]
+	fetched := rp.fetched
+	rp.lock.RUnlock()
+	if ok {
+		return key, nil
+	}
+	// refetch the key set for unknown key id(key rotation), at most once a minute
+	if time.Since(fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	provider, err := rp.getProvider()
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	err = rp.getJSON(provider.JWKSURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC jwks: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 == nil && err2 == nil {
+				keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 == nil && err2 == nil {
+				keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			}
+		}
+	}
+
+	rp.lock.Lock()
+	rp.keys = keys
+	rp.fetched = time.Now()
+	rp.lock.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (rp *oidcRP) getJSON(url string, v interface{}) error {
+	res, err := rp.config.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func decodeJWTPart(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errors.New("malformed id token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed id token")
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed []byte, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported id token alg %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported id token alg %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			if rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil {
+				return nil
+			}
+		case "PS":
+			if rsa.VerifyPSS(k, hash, digest, sig, nil) == nil {
+				return nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid id token signature")
+}
@@ -0,0 +1,250 @@
+package rex
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// oidcTestIdP is a stand-in identity provider, the claims of the ID token are
+// changed by the tests.
+type oidcTestIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	lock   sync.Mutex
+	nonce  string
+	claims func(idp *oidcTestIdP) map[string]interface{}
+}
+
+func newOIDCTestIdP(t *testing.T) *oidcTestIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &oidcTestIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "test-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.claims(idp))})
+	})
+	idp.Server = httptest.NewServer(mux)
+	idp.claims = func(idp *oidcTestIdP) map[string]interface{} {
+		return idp.validClaims()
+	}
+	return idp
+}
+
+func (idp *oidcTestIdP) validClaims() map[string]interface{} {
+	idp.lock.Lock()
+	defer idp.lock.Unlock()
+	now := time.Now().Unix()
+	return map[string]interface{}{
+		"iss":    idp.URL,
+		"aud":    "client",
+		"sub":    "alice",
+		"name":   "Alice",
+		"groups": []string{"admin"},
+		"nonce":  idp.nonce,
+		"iat":    now,
+		"exp":    now + 300,
+	}
+}
+
+func (idp *oidcTestIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newOIDCTestAPI(idp *oidcTestIdP) *APIHandler {
+	api := &APIHandler{}
+	api.OIDC(OIDCConfig{
+		Issuer:      idp.URL,
+		ClientID:    "client",
+		RedirectURL: "http://app.test/oauth/callback",
+	})
+	api.Query("me", func(ctx *Context) interface{} {
+		if user, ok := ctx.ACLUser().(*OIDCUser); ok {
+			return user.Subject
+		}
+		return &Error{401, "unauthorized"}
+	})
+	return api
+}
+
+func oidcTestServe(api *APIHandler, method string, target string, cookie string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if cookie != "" {
+		r.Header.Set("Cookie", cookie)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+// sessionCookie returns the session cookie of the response, or the cookie if
+// the response doesn't set a new one.
+func sessionCookie(w *httptest.ResponseRecorder, cookie string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "x-session" {
+			return c.Name + "=" + c.Value
+		}
+	}
+	return cookie
+}
+
+// oidcTestLogin starts the login flow, and returns the session cookie and the
+// state of the flow.
+func oidcTestLogin(t *testing.T, api *APIHandler, idp *oidcTestIdP) (string, string) {
+	w := oidcTestServe(api, "POST", "/login", "")
+	if w.Code != 303 {
+		t.Fatalf("login: got %d %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), idp.URL+"/authorize?") {
+		t.Fatalf("login: invalid location %q", w.Header().Get("Location"))
+	}
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "client" {
+		t.Fatalf("login: invalid query %v", query)
+	}
+	idp.lock.Lock()
+	idp.nonce = query.Get("nonce")
+	idp.lock.Unlock()
+	return sessionCookie(w, ""), query.Get("state")
+}
+
+func TestOIDCLoginAndLogout(t *testing.T) {
+	idp := newOIDCTestIdP(t)
+	defer idp.Close()
+	api := newOIDCTestAPI(idp)
+
+	cookie, state := oidcTestLogin(t, api, idp)
+	w := oidcTestServe(api, "GET", "/oauth/callback?code=test-code&state="+url.QueryEscape(state), cookie)
+	if w.Code != 303 || w.Header().Get("Location") != "/" {
+		t.Fatalf("callback: got %d %s", w.Code, w.Body.String())
+	}
+	userCookie := sessionCookie(w, cookie)
+	if userCookie == cookie {
+		t.Fatal("callback: the session is not renewed")
+	}
+	if w := oidcTestServe(api, "GET", "/me", userCookie); w.Code != 200 || !strings.Contains(w.Body.String(), "alice") {
+		t.Fatalf("me: got %d %s", w.Code, w.Body.String())
+	}
+
+	w = oidcTestServe(api, "POST", "/logout", userCookie)
+	if w.Code != 303 {
+		t.Fatalf("logout: got %d %s", w.Code, w.Body.String())
+	}
+	if sessionCookie(w, userCookie) == userCookie {
+		t.Fatal("logout: the session is not renewed")
+	}
+	// the old session is destroyed
+	if w := oidcTestServe(api, "GET", "/me", userCookie); w.Code != 401 {
+		t.Fatalf("me after logout: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCCallbackErrors(t *testing.T) {
+	idp := newOIDCTestIdP(t)
+	defer idp.Close()
+	api := newOIDCTestAPI(idp)
+
+	tests := []struct {
+		name   string
+		state  string
+		claims func(claims map[string]interface{})
+		status int
+	}{
+		{name: "state mismatch", state: "bad-state", status: 400},
+		{name: "nonce mismatch", claims: func(c map[string]interface{}) { c["nonce"] = "bad-nonce" }, status: 401},
+		{name: "bad aud", claims: func(c map[string]interface{}) { c["aud"] = "other-client" }, status: 401},
+		{name: "bad iss", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, status: 401},
+		{name: "expired", claims: func(c map[string]interface{}) {
+			c["iat"] = time.Now().Add(-time.Hour).Unix()
+			c["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+		}, status: 401},
+	}
+	for _, test := range tests {
+		modify := test.claims
+		idp.claims = func(idp *oidcTestIdP) map[string]interface{} {
+			claims := idp.validClaims()
+			if modify != nil {
+				modify(claims)
+			}
+			return claims
+		}
+		cookie, state := oidcTestLogin(t, api, idp)
+		if test.state != "" {
+			state = test.state
+		}
+		w := oidcTestServe(api, "GET", "/oauth/callback?code=test-code&state="+url.QueryEscape(state), cookie)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, w.Code, w.Body.String(), test.status)
+		}
+		if w := oidcTestServe(api, "GET", "/me", sessionCookie(w, cookie)); w.Code != 401 {
+			t.Errorf("%s: logged in, got %d %s", test.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOIDCCookiePath(t *testing.T) {
+	idp := newOIDCTestIdP(t)
+	defer idp.Close()
+	api := newOIDCTestAPI(idp)
+	api.Query("visit", func(ctx *Context) interface{} {
+		ctx.Session().Set("visited", []byte("1"))
+		return "ok"
+	})
+
+	// the cookie of the login flow is shared by the callback and the app
+	w := oidcTestServe(api, "POST", "/login", "")
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Path != "/" {
+		t.Fatalf("login: got cookies %v, want the root path", cookies)
+	}
+	// other sessions keep the default path of the cookie
+	w = oidcTestServe(api, "GET", "/visit", "")
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Path != "" {
+		t.Fatalf("visit: got cookies %v, want the default path", cookies)
+	}
+}
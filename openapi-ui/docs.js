@@ -0,0 +1,230 @@
+// The API docs viewer of the OpenAPI document, it's bundled with rex to work
+// offline and under the strict Content-Security-Policy.
+(function () {
+  "use strict";
+
+  var root = document.getElementById("docs");
+  var spec = {};
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (key) {
+      if (key === "class") {
+        node.className = attrs[key];
+      } else {
+        node.setAttribute(key, attrs[key]);
+      }
+    });
+    (children || []).forEach(function (child) {
+      if (child != null) {
+        node.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+      }
+    });
+    return node;
+  }
+
+  function resolve(schema) {
+    var seen = 0;
+    while (schema && schema.$ref && seen++ < 32) {
+      var name = schema.$ref.replace("#/components/schemas/", "");
+      schema = ((spec.components || {}).schemas || {})[name];
+    }
+    return schema || {};
+  }
+
+  // example returns a sample value of the schema.
+  function example(schema, depth) {
+    schema = resolve(schema);
+    if (depth > 8) {
+      return null;
+    }
+    var type = Array.isArray(schema.type) ? schema.type[0] : schema.type;
+    if (schema.enum) {
+      return schema.enum[0];
+    }
+    switch (type) {
+      case "object":
+        var obj = {};
+        Object.keys(schema.properties || {}).forEach(function (key) {
+          obj[key] = example(schema.properties[key], depth + 1);
+        });
+        return obj;
+      case "array":
+        return [example(schema.items, depth + 1)];
+      case "integer":
+      case "number":
+        return 0;
+      case "boolean":
+        return false;
+      case "string":
+        return schema.format === "date-time" ? new Date(0).toISOString() : "string";
+    }
+    return null;
+  }
+
+  function schemaBlock(schema) {
+    if (!schema) {
+      return null;
+    }
+    return el("pre", {}, [JSON.stringify(example(schema, 0), null, 2)]);
+  }
+
+  function paramsTable(params, inputs) {
+    var rows = params.map(function (p) {
+      var input = el("input", { placeholder: p.in === "path" ? "required" : "" });
+      inputs.push({ param: p, input: input });
+      var schema = resolve(p.schema);
+      return el("tr", {}, [
+        el("td", {}, [el("code", {}, [p.name]), p.required ? el("span", { class: "required" }, [" *"]) : null]),
+        el("td", {}, [p.in]),
+        el("td", {}, [String(schema.type || "")]),
+        el("td", {}, [input]),
+      ]);
+    });
+    return el("table", {}, [
+      el("thead", {}, [el("tr", {}, [el("th", {}, ["Name"]), el("th", {}, ["In"]), el("th", {}, ["Type"]), el("th", {}, ["Value"])])]),
+      el("tbody", {}, rows),
+    ]);
+  }
+
+  function operation(method, pathname, op) {
+    var inputs = [];
+    var body = el("div", { class: "op-body" });
+    if (op.description) {
+      body.appendChild(el("p", {}, [op.description]));
+    }
+    if ((op.parameters || []).length > 0) {
+      body.appendChild(el("h4", {}, ["Parameters"]));
+      body.appendChild(paramsTable(op.parameters, inputs));
+    }
+    var textarea = null;
+    var content = (op.requestBody || {}).content || {};
+    if (content["application/json"]) {
+      textarea = el("textarea", {}, []);
+      textarea.value = JSON.stringify(example(content["application/json"].schema, 0), null, 2);
+      body.appendChild(el("h4", {}, ["Request Body"]));
+      body.appendChild(textarea);
+    }
+    Object.keys(op.responses || {}).forEach(function (status) {
+      var res = op.responses[status];
+      var schema = ((res.content || {})["application/json"] || {}).schema;
+      body.appendChild(el("h4", {}, ["Response " + status]));
+      body.appendChild(schema ? schemaBlock(schema) : el("p", {}, [res.description || ""]));
+    });
+
+    var result = el("div", {});
+    var button = el("button", { type: "button" }, ["Send"]);
+    button.addEventListener("click", function () {
+      send(method, pathname, inputs, textarea, result);
+    });
+    body.appendChild(button);
+    body.appendChild(result);
+
+    var security = (op.security || []).map(function (s) {
+      return (s.session || []).join(", ");
+    }).join("; ");
+    return el("details", { class: "op" + (op.deprecated ? " deprecated" : "") }, [
+      el("summary", {}, [
+        el("span", { class: "method " + method }, [method.toUpperCase()]),
+        el("span", { class: "path" }, [pathname]),
+        el("span", { class: "summary" }, [op.summary || ""]),
+        security ? el("span", { class: "lock", title: "permissions" }, [security]) : null,
+      ]),
+      body,
+    ]);
+  }
+
+  function send(method, pathname, inputs, textarea, result) {
+    var url = pathname;
+    var query = new URLSearchParams();
+    var headers = {};
+    inputs.forEach(function (item) {
+      var value = item.input.value;
+      if (value === "") {
+        return;
+      }
+      switch (item.param.in) {
+        case "path":
+          url = url.replace("{" + item.param.name + "}", encodeURIComponent(value));
+          break;
+        case "header":
+          headers[item.param.name] = value;
+          break;
+        default:
+          query.append(item.param.name, value);
+      }
+    });
+    var init = { method: method.toUpperCase(), headers: headers, credentials: "same-origin" };
+    if (method === "post") {
+      if (textarea) {
+        headers["Content-Type"] = "application/json";
+        init.body = textarea.value;
+      } else {
+        headers["Content-Type"] = "application/x-www-form-urlencoded";
+        init.body = query.toString();
+        query = new URLSearchParams();
+      }
+    }
+    var qs = query.toString();
+    if (qs) {
+      url += "?" + qs;
+    }
+    var base = ((spec.servers || [])[0] || {}).url || "";
+    result.textContent = "";
+    fetch(base.replace(/\/$/, "") + url, init).then(function (res) {
+      return res.text().then(function (text) {
+        try {
+          text = JSON.stringify(JSON.parse(text), null, 2);
+        } catch (e) {
+          // not a json response
+        }
+        result.appendChild(el("h4", {}, ["Result"]));
+        result.appendChild(el("p", { class: "status" }, [res.status + " " + res.statusText]));
+        result.appendChild(el("pre", {}, [text]));
+      });
+    }).catch(function (err) {
+      result.appendChild(el("p", { class: "error" }, [String(err)]));
+    });
+  }
+
+  function render() {
+    var info = spec.info || {};
+    root.textContent = "";
+    root.appendChild(el("h1", {}, [info.title || "API", " ", el("small", { class: "version" }, [info.version || ""])]));
+    if (info.description) {
+      root.appendChild(el("p", {}, [info.description]));
+    }
+
+    // the operations are grouped by the first tag
+    var groups = {};
+    var paths = spec.paths || {};
+    Object.keys(paths).sort().forEach(function (pathname) {
+      ["get", "post"].forEach(function (method) {
+        var op = paths[pathname][method];
+        if (op) {
+          var tag = (op.tags || [])[0] || "default";
+          (groups[tag] = groups[tag] || []).push(operation(method, pathname, op));
+        }
+      });
+    });
+    Object.keys(groups).sort().forEach(function (tag) {
+      root.appendChild(el("h2", {}, [tag]));
+      groups[tag].forEach(function (node) {
+        root.appendChild(node);
+      });
+    });
+  }
+
+  fetch(root.getAttribute("data-url"), { credentials: "same-origin" }).then(function (res) {
+    if (!res.ok) {
+      throw new Error(res.status + " " + res.statusText);
+    }
+    return res.json();
+  }).then(function (data) {
+    spec = data;
+    render();
+  }).catch(function (err) {
+    root.textContent = "";
+    root.appendChild(el("p", { class: "error" }, ["Failed to load the OpenAPI document: " + err.message]));
+  });
+})();
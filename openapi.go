@@ -0,0 +1,855 @@
+package rex
+
+import (
+	"bytes"
+	"embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openapiUI is the bundled docs page, it works offline and under the strict
+// Content-Security-Policy. It's not the Swagger UI: the Swagger UI 4 releases
+// reject OpenAPI 3.1 documents, and the Swagger UI 5 bundle is over 1MB.
+//
+//go:embed openapi-ui
+var openapiUI embed.FS
+
+// openapiUIModTime is the modtime of the bundled docs page
+var openapiUIModTime = time.Now()
+
+// EndpointDoc describes an endpoint for the OpenAPI document.
+type EndpointDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Params is a struct(or a pointer to struct) with the `form`, `path` and `header` tags
+	Params interface{}
+	// Request is the JSON request body of the mutation
+	Request interface{}
+	// Response is the response body
+	Response   interface{}
+	Deprecated bool
+	// Permissions is the permissions of the ACL, it's set by the Endpoint.ACL
+	Permissions []string
+}
+
+// Endpoint is a registered query or mutation.
+type Endpoint struct {
+	api    *APIHandler
+	method string
+	path   string
+}
+
+// Doc attaches the document to the endpoint:
+//
+//	rex.Query("user/*", rex.Typed(getUser)).Doc(rex.EndpointDoc{
+//		Summary:  "Get the user",
+//		Params:   GetUser{},
+//		Response: &User{},
+//	})
+func (e *Endpoint) Doc(doc EndpointDoc) *Endpoint {
+	if e.path != "" {
+		d := e.doc()
+		if doc.Permissions == nil {
+			doc.Permissions = d.Permissions
+		}
+		*d = doc
+	}
+	return e
+}
+
+// ACL prepends the ACL middleware to the endpoint, the permissions are
+// described as the security requirement of the endpoint.
+func (e *Endpoint) ACL(permissions ...string) *Endpoint {
+	if e.path != "" {
+		handles := e.api.queries
+		if e.method == "POST" {
+			handles = e.api.mutations
+		}
+		handles[e.path] = append([]Handle{ACL(permissions...)}, handles[e.path]...)
+		d := e.doc()
+		for _, p := range permissions {
+			if p = strings.TrimSpace(p); p != "" {
+				d.Permissions = append(d.Permissions, p)
+			}
+		}
+	}
+	return e
+}
+
+func (e *Endpoint) doc() *EndpointDoc {
+	if e.api.docs == nil {
+		e.api.docs = map[string]*EndpointDoc{}
+	}
+	key := e.method + " " + e.path
+	d, ok := e.api.docs[key]
+	if !ok {
+		d = &EndpointDoc{}
+		e.api.docs[key] = d
+	}
+	return d
+}
+
+// OpenAPIConfig contains options for the OpenAPI document.
+type OpenAPIConfig struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	// Endpoint serves the document as "{endpoint}.json" and "{endpoint}.yaml", defaults to "openapi"
+	Endpoint string
+	// UIEndpoint serves the docs page, defaults to "docs", "-" to disable
+	UIEndpoint string
+}
+
+// OpenAPI adds the queries to serve the OpenAPI 3.1 document of the APIHandler
+// and the docs page.
+func (a *APIHandler) OpenAPI(config OpenAPIConfig) {
+	if config.Endpoint == "" {
+		config.Endpoint = "openapi"
+	}
+	if config.UIEndpoint == "" {
+		config.UIEndpoint = "docs"
+	}
+	a.Query(config.Endpoint+".json", func(ctx *Context) interface{} {
+		return a.OpenAPIDocument(config)
+	})
+	a.Query(config.Endpoint+".yaml", func(ctx *Context) interface{} {
+		ctx.SetHeader("Content-Type", "application/yaml; charset=utf-8")
+		return EncodeYAML(a.OpenAPIDocument(config))
+	})
+	if config.UIEndpoint != "-" {
+		a.Query(config.UIEndpoint, func(ctx *Context) interface{} {
+			prefix := path.Join("/", strings.Trim(a.Prefix, "/"))
+			index, err := openapiUI.ReadFile("openapi-ui/index.html")
+			if err != nil {
+				return err
+			}
+			return HTML(string(index), map[string]string{
+				"title": config.Title,
+				"url":   path.Join(prefix, config.Endpoint+".json"),
+				"base":  path.Join(prefix, config.UIEndpoint),
+			})
+		})
+		for _, name := range []string{"docs.js", "docs.css"} {
+			name := name
+			a.Query(config.UIEndpoint+"/"+name, func(ctx *Context) interface{} {
+				data, err := openapiUI.ReadFile("openapi-ui/" + name)
+				if err != nil {
+					return err
+				}
+				return Content(name, openapiUIModTime, bytes.NewReader(data))
+			})
+		}
+	}
+}
+
+// OpenAPI adds the OpenAPI document of the default APIHandler.
+func OpenAPI(config OpenAPIConfig) {
+	defaultAPIHanlder.OpenAPI(config)
+}
+
+// OpenAPIDocument returns the OpenAPI 3.1 document of the registered queries
+// and mutations, the queries are described as GET operations and the
+// mutations as POST operations.
+func (a *APIHandler) OpenAPIDocument(config OpenAPIConfig) map[string]interface{} {
+	g := &schemaGenerator{}
+	paths := map[string]interface{}{}
+	usesACL := false
+
+	for _, method := range []string{"GET", "POST"} {
+		apiHandles := a.queries
+		if method == "POST" {
+			apiHandles = a.mutations
+		}
+		for endpoint := range apiHandles {
+			doc := &EndpointDoc{}
+			if d, ok := a.docs[method+" "+endpoint]; ok {
+				*doc = *d
+			}
+			if doc.Request == nil && method == "POST" && hasJSONFields(doc.Params) {
+				doc.Request = doc.Params
+			}
+			op := map[string]interface{}{
+				"operationId": operationID(method, endpoint),
+				"responses": map[string]interface{}{
+					"200": g.responseObject(doc.Response),
+					"default": map[string]interface{}{
+						"description": "Error",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": g.errorSchema()},
+						},
+					},
+				},
+			}
+			if doc.Summary != "" {
+				op["summary"] = doc.Summary
+			}
+			if doc.Description != "" {
+				op["description"] = doc.Description
+			}
+			if len(doc.Tags) > 0 {
+				op["tags"] = doc.Tags
+			}
+			if doc.Deprecated {
+				op["deprecated"] = true
+			}
+
+			segments := strings.Split(endpoint, "/")
+			params, formSchema := g.parameters(doc.Params, method, segments, doc.Request != nil)
+			if len(params) > 0 {
+				op["parameters"] = params
+			}
+			if method == "POST" {
+				content := map[string]interface{}{}
+				if formSchema != nil {
+					content["application/x-www-form-urlencoded"] = map[string]interface{}{"schema": formSchema}
+				}
+				if doc.Request != nil {
+					content["application/json"] = map[string]interface{}{"schema": g.bodySchema(reflect.TypeOf(doc.Request))}
+				}
+				if len(content) > 0 {
+					op["requestBody"] = map[string]interface{}{"content": content}
+				}
+			}
+
+			if permissions := sortedSet(doc.Permissions); len(permissions) > 0 {
+				usesACL = true
+				op["security"] = []interface{}{map[string]interface{}{"session": permissions}}
+			}
+
+			pathname := "/{path}"
+			if endpoint != "*" {
+				for i, s := range segments {
+					if s == "*" {
+						segments[i] = "{" + pathParamName(doc.Params, i) + "}"
+					}
+				}
+				pathname = "/" + strings.Join(segments, "/")
+			}
+			if a.Prefix != "" {
+				pathname = path.Join("/", strings.Trim(a.Prefix, "/"), pathname)
+			}
+			item, ok := paths[pathname].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				paths[pathname] = item
+			}
+			item[strings.ToLower(method)] = op
+		}
+	}
+
+	info := map[string]interface{}{"title": config.Title, "version": config.Version}
+	if config.Title == "" {
+		info["title"] = "API"
+	}
+	if config.Version == "" {
+		info["version"] = "1.0.0"
+	}
+	if config.Description != "" {
+		info["description"] = config.Description
+	}
+	document := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(config.Servers) > 0 {
+		servers := make([]interface{}, len(config.Servers))
+		for i, url := range config.Servers {
+			servers[i] = map[string]interface{}{"url": url}
+		}
+		document["servers"] = servers
+	}
+	components := map[string]interface{}{"schemas": g.components()}
+	if usesACL {
+		name := "x-session"
+		if defaultSIDStore.CookieName != "" {
+			name = defaultSIDStore.CookieName
+		}
+		components["securitySchemes"] = map[string]interface{}{
+			"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": name},
+		}
+	}
+	document["components"] = components
+	return document
+}
+
+// hasJSONFields checks the struct whether has the fields with `json` tag.
+func hasJSONFields(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("json"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedSet returns the sorted unique strings.
+func sortedSet(list []string) []string {
+	set := map[string]struct{}{}
+	for _, s := range list {
+		set[s] = struct{}{}
+	}
+	sorted := make([]string, 0, len(set))
+	for s := range set {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+var nonWordRegexp = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func operationID(method string, endpoint string) string {
+	prefix := "query"
+	if method == "POST" {
+		prefix = "mutation"
+	}
+	words := nonWordRegexp.Split(strings.ReplaceAll(endpoint, "*", "any"), -1)
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return prefix + strings.Join(words, "")
+}
+
+func pathParamName(params interface{}, index int) string {
+	if params != nil {
+		t := reflect.TypeOf(params)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if tag, ok := f.Tag.Lookup("path"); ok && strings.Split(tag, ",")[0] == strconv.Itoa(index) {
+					return lowerFirst(f.Name)
+				}
+			}
+		}
+	}
+	return "p" + strconv.Itoa(index)
+}
+
+// lowerFirst lowercases the leading upper case letters, like "ID" to "id" and "UserID" to "userID".
+func lowerFirst(s string) string {
+	r := []rune(s)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+var (
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaGenerator generates the JSON schemas of the types, the named structs
+// are stored as the components, they are named after all the schemas are
+// generated since the names depend on each other.
+type schemaGenerator struct {
+	schemas   map[reflect.Type]map[string]interface{}
+	refs      map[reflect.Type][]map[string]interface{}
+	usesError bool
+}
+
+func (g *schemaGenerator) errorSchema() map[string]interface{} {
+	g.usesError = true
+	return map[string]interface{}{"$ref": "#/components/schemas/Error"}
+}
+
+// components returns the component schemas and resolves the refs. A schema is
+// named by the type name, like "User" or "Page_User" for `Page[models.User]`,
+// the conflicting names are qualified by the package names, like "models.User".
+func (g *schemaGenerator) components() map[string]interface{} {
+	groups := map[string][]reflect.Type{}
+	for t := range g.schemas {
+		name := schemaName(t)
+		groups[name] = append(groups[name], t)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	components := map[string]interface{}{}
+	if g.usesError {
+		components["Error"] = map[string]interface{}{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"status", "message"},
+					"properties": map[string]interface{}{
+						"status":  map[string]interface{}{"type": "integer"},
+						"message": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		}
+	}
+	for _, name := range names {
+		types := groups[name]
+		sort.Slice(types, func(i, j int) bool {
+			return types[i].PkgPath() < types[j].PkgPath()
+		})
+		for _, t := range types {
+			key := name
+			if len(types) > 1 || name == "Error" {
+				// the "Error" is reserved for the error response
+				pkg := nonWordRegexp.ReplaceAllString(path.Base(t.PkgPath()), "_")
+				key = pkg + "." + name
+				for i := 2; components[key] != nil; i++ {
+					key = pkg + "." + name + strconv.Itoa(i)
+				}
+			}
+			components[key] = g.schemas[t]
+			for _, ref := range g.refs[t] {
+				ref["$ref"] = "#/components/schemas/" + key
+			}
+		}
+	}
+	return components
+}
+
+var typePathRegexp = regexp.MustCompile(`[^\[\],*\s]+`)
+
+// schemaName returns the type name without the package paths of the type
+// arguments, like "Page_User" for `Page[github.com/app/models.User]`.
+func schemaName(t reflect.Type) string {
+	name := typePathRegexp.ReplaceAllStringFunc(t.Name(), func(s string) string {
+		if i := strings.LastIndexByte(s, '/'); i >= 0 {
+			s = s[i+1:]
+		}
+		if i := strings.LastIndexByte(s, '.'); i >= 0 {
+			s = s[i+1:]
+		}
+		return s
+	})
+	return strings.Trim(nonWordRegexp.ReplaceAllString(name, "_"), "_")
+}
+
+func (g *schemaGenerator) responseObject(v interface{}) map[string]interface{} {
+	res := map[string]interface{}{"description": "OK"}
+	if v != nil {
+		res["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(v))},
+		}
+	}
+	return res
+}
+
+// schema returns the JSON schema of the type, the named structs are stored in the components.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case jsonRawMessageType:
+		return map[string]interface{}{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int" + strconv.Itoa(t.Bits())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t, false)
+		}
+		if g.schemas == nil {
+			g.schemas = map[reflect.Type]map[string]interface{}{}
+			g.refs = map[reflect.Type][]map[string]interface{}{}
+		}
+		if _, ok := g.schemas[t]; !ok {
+			// placeholder for recursive types
+			g.schemas[t] = map[string]interface{}{}
+			g.schemas[t] = g.structSchema(t, false)
+		}
+		ref := map[string]interface{}{"$ref": ""}
+		g.refs[t] = append(g.refs[t], ref)
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// bodySchema returns the schema of the JSON request body, the fields of the
+// params struct that are decoded from the `form`, `path` or `header` tags are
+// not in the body.
+func (g *schemaGenerator) bodySchema(t reflect.Type) map[string]interface{} {
+	st := t
+	for st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct && hasParamFields(st) {
+		return g.structSchema(st, true)
+	}
+	return g.schema(t)
+}
+
+// hasParamFields checks the struct whether has the fields that are decoded
+// from the `form`, `path` or `header` tags but not the `json` tag.
+func hasParamFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if isParamField(f) {
+			return true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasParamFields(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func isParamField(f reflect.StructField) bool {
+	if _, ok := f.Tag.Lookup("json"); ok {
+		return false
+	}
+	for _, in := range []string{"form", "path", "header"} {
+		if _, ok := f.Tag.Lookup(in); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type, skipParams bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (skipParams && isParamField(f)) {
+				continue
+			}
+			name, opts := tag, ""
+			if i := strings.IndexByte(tag, ','); i >= 0 {
+				name, opts = tag[:i], tag[i+1:]
+			}
+			ft := f.Type
+			if f.Anonymous && name == "" {
+				for ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft)
+					continue
+				}
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = g.schema(ft)
+			if !strings.Contains(","+opts+",", ",omitempty,") && ft.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// parameters returns the OpenAPI parameters of the params struct, and the
+// schema of the form body for mutations, the form fields are in the query if
+// the body of the mutation is JSON.
+func (g *schemaGenerator) parameters(params interface{}, method string, segments []string, jsonBody bool) ([]interface{}, map[string]interface{}) {
+	var list []interface{}
+	for i, s := range segments {
+		if s == "*" {
+			name := "path"
+			if len(segments) > 1 {
+				name = pathParamName(params, i)
+			}
+			list = append(list, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   g.pathParamSchema(params, i),
+			})
+		}
+	}
+	if params == nil {
+		return list, nil
+	}
+	t := reflect.TypeOf(params)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return list, nil
+	}
+
+	formProperties := map[string]interface{}{}
+	formRequired := []string{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Tag == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			for _, in := range []string{"form", "header"} {
+				tag, ok := f.Tag.Lookup(in)
+				if !ok || tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if i := strings.IndexByte(tag, ','); i >= 0 {
+					name, opts = tag[:i], tag[i+1:]
+				}
+				if name == "" {
+					name = f.Name
+				}
+				required := strings.Contains(","+opts+",", ",required,")
+				schema := g.paramSchema(f)
+				if in == "form" && method == "POST" && !jsonBody {
+					formProperties[name] = schema
+					if required {
+						formRequired = append(formRequired, name)
+					}
+					continue
+				}
+				p := map[string]interface{}{"name": name, "in": "query", "schema": schema}
+				if in == "header" {
+					p["in"] = "header"
+				}
+				if required {
+					p["required"] = true
+				}
+				ft := f.Type
+				for ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Map || (ft.Kind() == reflect.Struct && ft != timeType) {
+					p["style"] = "deepObject"
+					p["explode"] = true
+				}
+				list = append(list, p)
+			}
+		}
+	}
+	walk(t)
+
+	var formSchema map[string]interface{}
+	if len(formProperties) > 0 {
+		formSchema = map[string]interface{}{"type": "object", "properties": formProperties}
+		if len(formRequired) > 0 {
+			formSchema["required"] = formRequired
+		}
+	}
+	return list, formSchema
+}
+
+// paramSchema returns the schema of the param field, the durations are
+// parsed from strings like "1h30m".
+func (g *schemaGenerator) paramSchema(f reflect.StructField) map[string]interface{} {
+	ft := f.Type
+	for ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	var schema map[string]interface{}
+	if ft == durationType {
+		schema = map[string]interface{}{"type": "string", "format": "duration"}
+	} else if ft == timeType && f.Tag.Get("layout") != "" {
+		schema = map[string]interface{}{"type": "string"}
+	} else {
+		schema = g.schema(ft)
+	}
+	if def, ok := f.Tag.Lookup("default"); ok {
+		if v, ok := defaultValue(ft, def, f.Tag); ok {
+			schema["default"] = v
+		}
+	}
+	return schema
+}
+
+// defaultValue converts the `default` tag to the value of the field type like
+// the decoding, the durations and times are kept as the strings.
+func defaultValue(t reflect.Type, def string, tag reflect.StructTag) (interface{}, bool) {
+	if t == durationType || t == timeType {
+		return def, true
+	}
+	v := reflect.New(t).Elem()
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		values := strings.Split(def, ",")
+		v = reflect.MakeSlice(t, len(values), len(values))
+		for i, s := range values {
+			if setValue(v.Index(i), s, tag) != nil {
+				return nil, false
+			}
+		}
+	} else if setValue(v, def, tag) != nil {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func (g *schemaGenerator) pathParamSchema(params interface{}, index int) map[string]interface{} {
+	if params != nil {
+		t := reflect.TypeOf(params)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if tag, ok := f.Tag.Lookup("path"); ok && strings.Split(tag, ",")[0] == strconv.Itoa(index) {
+					return g.paramSchema(f)
+				}
+			}
+		}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+var yamlPlainKeyRegexp = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./{}-]*$`)
+
+// EncodeYAML encodes the JSON-compatible value as YAML.
+func EncodeYAML(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(&recoverError{500, err.Error()})
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.Decode(&value)
+
+	buf := bytes.NewBuffer(nil)
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		writeYAML(buf, value, 0, false)
+	default:
+		buf.WriteString(yamlScalar(value))
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int, inline bool) {
+	pad := strings.Repeat(" ", indent)
+	switch a := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(a))
+		for key := range a {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			if !(inline && i == 0) {
+				buf.WriteString(pad)
+			}
+			k := key
+			if !yamlPlainKeyRegexp.MatchString(key) || key == "true" || key == "false" || key == "null" {
+				k = yamlScalar(key)
+			}
+			buf.WriteString(k)
+			buf.WriteByte(':')
+			writeYAMLValue(buf, a[key], indent+2)
+		}
+	case []interface{}:
+		for _, item := range a {
+			buf.WriteString(pad)
+			buf.WriteByte('-')
+			if m, ok := item.(map[string]interface{}); ok && len(m) > 0 {
+				buf.WriteByte(' ')
+				writeYAML(buf, m, indent+2, true)
+			} else if l, ok := item.([]interface{}); ok && len(l) > 0 {
+				buf.WriteByte('\n')
+				writeYAML(buf, l, indent+2, false)
+			} else {
+				writeYAMLValue(buf, item, indent+2)
+			}
+		}
+	}
+}
+
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch a := v.(type) {
+	case map[string]interface{}:
+		if len(a) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAML(buf, a, indent, false)
+	case []interface{}:
+		if len(a) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAML(buf, a, indent, false)
+	default:
+		buf.WriteByte(' ')
+		buf.WriteString(yamlScalar(v))
+		buf.WriteByte('\n')
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch a := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		// JSON strings are valid YAML double-quoted scalars
+		data, _ := json.Marshal(a)
+		return string(data)
+	default:
+		return fmt.Sprint(a)
+	}
+}
@@ -0,0 +1,187 @@
+package rex
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type openapiTestUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type openapiTestPage[T any] struct {
+	Items []T  `json:"items"`
+	Next  *int `json:"next"`
+}
+
+type openapiTestParams struct {
+	ID      int           `path:"1"`
+	Token   string        `form:"token,required"`
+	Page    int           `form:"page" default:"1"`
+	Tags    []string      `form:"tags" default:"a,b"`
+	Debug   bool          `form:"debug" default:"true"`
+	Timeout time.Duration `form:"timeout" default:"1m"`
+	Name    string        `json:"name"`
+}
+
+// openapiTestGet returns the JSON of the value at the path of the document.
+func openapiTestGet(t *testing.T, doc map[string]interface{}, keys ...string) string {
+	var v interface{} = doc
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: not an object", strings.Join(keys, "."))
+		}
+		v = m[key]
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	api := &APIHandler{}
+	api.Query("users", func(ctx *Context) interface{} { return nil }).Doc(EndpointDoc{
+		Response: openapiTestPage[openapiTestUser]{},
+	})
+	api.Mutation("users/*", func(ctx *Context) interface{} { return nil }).Doc(EndpointDoc{
+		Params:   openapiTestParams{},
+		Response: &openapiTestUser{},
+	}).ACL("admin", "owner")
+	api.Query("errors", func(ctx *Context) interface{} { return nil }).ACL("admin").Doc(EndpointDoc{
+		Response: struct {
+			Error    *Error
+			URLError *url.Error
+		}{},
+	})
+	doc := api.OpenAPIDocument(OpenAPIConfig{})
+
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{
+			keys: []string{"paths", "/users", "get", "responses", "200", "content", "application/json", "schema"},
+			want: `{"$ref":"#/components/schemas/openapiTestPage_openapiTestUser"}`,
+		},
+		{
+			keys: []string{"components", "schemas", "openapiTestPage_openapiTestUser", "properties", "items"},
+			want: `{"items":{"$ref":"#/components/schemas/openapiTestUser"},"type":"array"}`,
+		},
+		{
+			keys: []string{"paths", "/errors", "get", "responses", "200", "content", "application/json", "schema", "properties"},
+			want: `{"Error":{"$ref":"#/components/schemas/rex.Error"},"URLError":{"$ref":"#/components/schemas/url.Error"}}`,
+		},
+		{
+			keys: []string{"paths", "/errors", "get", "responses", "default", "content", "application/json", "schema"},
+			want: `{"$ref":"#/components/schemas/Error"}`,
+		},
+		{
+			keys: []string{"paths", "/errors", "get", "security"},
+			want: `[{"session":["admin"]}]`,
+		},
+		{
+			keys: []string{"paths", "/users/{id}", "post", "security"},
+			want: `[{"session":["admin","owner"]}]`,
+		},
+		{
+			keys: []string{"paths", "/users/{id}", "post", "requestBody"},
+			want: `{"content":{"application/json":{"schema":{"properties":{"name":{"type":"string"}},"required":["name"],"type":"object"}}}}`,
+		},
+		{
+			keys: []string{"paths", "/users/{id}", "post", "parameters"},
+			want: `[{"in":"path","name":"id","required":true,"schema":{"format":"int64","type":"integer"}},` +
+				`{"in":"query","name":"token","required":true,"schema":{"type":"string"}},` +
+				`{"in":"query","name":"page","schema":{"default":1,"format":"int64","type":"integer"}},` +
+				`{"in":"query","name":"tags","schema":{"default":["a","b"],"items":{"type":"string"},"type":"array"}},` +
+				`{"in":"query","name":"debug","schema":{"default":true,"type":"boolean"}},` +
+				`{"in":"query","name":"timeout","schema":{"default":"1m","format":"duration","type":"string"}}]`,
+		},
+	}
+	for _, test := range tests {
+		if got := openapiTestGet(t, doc, test.keys...); got != test.want {
+			t.Errorf("%s: got %s, want %s", strings.Join(test.keys, "."), got, test.want)
+		}
+	}
+}
+
+func TestEndpointACL(t *testing.T) {
+	api := &APIHandler{}
+	api.Query("admin", func(ctx *Context) interface{} { return "ok" }).ACL("admin")
+	r := httptest.NewRequest("GET", "/admin", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 403 {
+		t.Fatalf("got %d %s, want 403", w.Code, w.Body.String())
+	}
+}
+
+func TestEncodeYAML(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: "text", want: "\"text\"\n"},
+		{value: 1.5, want: "1.5\n"},
+		{value: map[string]interface{}{}, want: ""},
+		{
+			value: map[string]interface{}{"b": 1, "a": true, "c": nil},
+			want:  "a: true\nb: 1\nc: null\n",
+		},
+		{
+			value: map[string]interface{}{"/users/{id}": map[string]interface{}{}, "200": "OK", "true": "yes", "$ref": "x"},
+			want:  "\"$ref\": \"x\"\n/users/{id}: {}\n\"200\": \"OK\"\n\"true\": \"yes\"\n",
+		},
+		{
+			value: map[string]interface{}{"list": []interface{}{}, "text": "a: b\n#c"},
+			want:  "list: []\ntext: \"a: b\\n#c\"\n",
+		},
+		{
+			value: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"name": "a", "tags": []string{"x"}},
+				[]interface{}{1, 2},
+				"s",
+			}},
+			want: "items:\n  - name: \"a\"\n    tags:\n      - \"x\"\n  -\n    - 1\n    - 2\n  - \"s\"\n",
+		},
+	}
+	for _, test := range tests {
+		if got := EncodeYAML(test.value); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestOpenAPIDocsPage(t *testing.T) {
+	api := &APIHandler{Prefix: "v2"}
+	api.OpenAPI(OpenAPIConfig{Title: "Test API"})
+
+	tests := []struct {
+		target      string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{target: "/v2/docs", status: 200, contentType: "text/html", contains: `data-url="/v2/openapi.json"`},
+		{target: "/v2/docs", status: 200, contentType: "text/html", contains: `<script src="/v2/docs/docs.js">`},
+		{target: "/v2/docs/docs.js", status: 200, contentType: "javascript", contains: "data-url"},
+		{target: "/v2/docs/docs.css", status: 200, contentType: "text/css"},
+		{target: "/v2/openapi.json", status: 200, contentType: "application/json", contains: `"title":"Test API"`},
+		{target: "/v2/openapi.yaml", status: 200, contentType: "application/yaml", contains: "openapi: \"3.1.0\"\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Header().Get("Content-Type"), test.contentType) || !strings.Contains(w.Body.String(), test.contains) {
+			t.Errorf("%s: got %d %q %.200s", test.target, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
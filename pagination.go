@@ -0,0 +1,190 @@
+package rex
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PaginationConfig contains options for the Pagination middleware.
+type PaginationConfig struct {
+	// DefaultLimit defaults to 20
+	DefaultLimit int
+	// MaxLimit defaults to 100
+	MaxLimit int
+	// SortFields is the allowlist of the sort fields
+	SortFields []string
+	// DefaultSort is the sort spec if the request doesn't have one, like "-created,name"
+	DefaultSort string
+}
+
+// SortField is a field of the sort spec.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Paging contains the parsed pagination of the request.
+type Paging struct {
+	Limit  int
+	Offset int
+	// Cursor is the decoded opaque cursor, empty for the first page or the offset-based pagination
+	Cursor string
+	Sort   []SortField
+}
+
+// Pagination returns a Pagination middleware that parses the `limit`, `offset`,
+// `cursor` and `sort` query parameters, the result is returned by ctx.Paging().
+func Pagination(config PaginationConfig) Handle {
+	if config.DefaultLimit <= 0 {
+		config.DefaultLimit = 20
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 100
+	}
+	if config.DefaultLimit > config.MaxLimit {
+		config.DefaultLimit = config.MaxLimit
+	}
+	return func(ctx *Context) interface{} {
+		query := ctx.R.URL.Query()
+		paging := &Paging{Limit: config.DefaultLimit}
+
+		if v := query.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				return &Error{400, "invalid limit"}
+			}
+			if limit > config.MaxLimit {
+				limit = config.MaxLimit
+			}
+			paging.Limit = limit
+		}
+		if v := query.Get("cursor"); v != "" {
+			cursor, err := base64.RawURLEncoding.DecodeString(v)
+			if err != nil {
+				return &Error{400, "invalid cursor"}
+			}
+			paging.Cursor = string(cursor)
+		} else if v := query.Get("offset"); v != "" {
+			offset, err := strconv.Atoi(v)
+			if err != nil || offset < 0 {
+				return &Error{400, "invalid offset"}
+			}
+			paging.Offset = offset
+		}
+
+		spec := query.Get("sort")
+		if spec == "" {
+			spec = config.DefaultSort
+		}
+		sort, err := parseSortSpec(spec, config.SortFields)
+		if err != nil {
+			return &Error{400, err.Error()}
+		}
+		paging.Sort = sort
+
+		ctx.paging = paging
+		return nil
+	}
+}
+
+func parseSortSpec(spec string, allowed []string) ([]SortField, error) {
+	var fields []SortField
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		field := SortField{Field: s}
+		if strings.HasPrefix(s, "-") {
+			field = SortField{Field: s[1:], Desc: true}
+		} else if strings.HasPrefix(s, "+") {
+			field.Field = s[1:]
+		}
+		ok := false
+		for _, name := range allowed {
+			if name == field.Field {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid sort field '%s'", field.Field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Paging returns the pagination parsed by the Pagination middleware, or
+// the default pagination(limit 20) if the middleware is not used.
+func (ctx *Context) Paging() *Paging {
+	if ctx.paging == nil {
+		ctx.paging = &Paging{Limit: 20}
+	}
+	return ctx.paging
+}
+
+type page struct {
+	items interface{}
+	next  interface{}
+}
+
+// Page replies to the request with a page of the items in the pagination envelope
+// and the `Link` headers(RFC 8288). The next is the cursor(string) or the
+// offset(int) of the next page, or nil for the last page.
+func Page(items interface{}, next interface{}) interface{} {
+	return &page{items, next}
+}
+
+type pageEnvelope struct {
+	Items      interface{} `json:"items"`
+	Limit      int         `json:"limit"`
+	Offset     *int        `json:"offset,omitempty"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	NextOffset *int        `json:"nextOffset,omitempty"`
+}
+
+func (ctx *Context) renderPage(p *page, status int) {
+	paging := ctx.Paging()
+	envelope := pageEnvelope{Items: ctx.selectFields(p.items), Limit: paging.Limit}
+	var links []string
+
+	link := func(rel string, set map[string]string) {
+		u := *ctx.R.URL
+		query := u.Query()
+		query.Del("cursor")
+		query.Del("offset")
+		for key, value := range set {
+			query.Set(key, value)
+		}
+		u.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel))
+	}
+
+	if next, ok := p.next.(string); ok || paging.Cursor != "" {
+		if next != "" {
+			envelope.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(next))
+			link("next", map[string]string{"cursor": envelope.NextCursor})
+		}
+	} else {
+		offset := paging.Offset
+		envelope.Offset = &offset
+		if next, ok := p.next.(int); ok {
+			envelope.NextOffset = &next
+			link("next", map[string]string{"offset": strconv.Itoa(next)})
+		}
+		if offset > 0 {
+			prev := offset - paging.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			link("prev", map[string]string{"offset": strconv.Itoa(prev)})
+		}
+	}
+	link("first", nil)
+
+	ctx.SetHeader("Link", strings.Join(links, ", "))
+	ctx.json(envelope, status)
+}
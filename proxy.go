@@ -0,0 +1,280 @@
+package rex
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyOptions contains options for the Proxy.
+type ProxyOptions struct {
+	// Upstreams are the other targets to balance the load by round robin
+	Upstreams []string
+	// Path rewrites the path of the upstream request, the "{0}", "{1}"... are
+	// replaced by the segments of the request path and "{*}" by the whole path,
+	// defaults to the request path without the Prefix of the APIHandler
+	Path string
+	// PreserveHost keeps the Host header of the request
+	PreserveHost bool
+	// RequestHeader sets the headers of the upstream request
+	RequestHeader map[string]string
+	// RemoveRequestHeader removes the headers of the upstream request
+	RemoveRequestHeader []string
+	// ResponseHeader sets the headers of the response
+	ResponseHeader map[string]string
+	// RemoveResponseHeader removes the headers of the response
+	RemoveResponseHeader []string
+	// Retries is the times to retry the next upstream for the failed GET and HEAD
+	// requests, defaults to the count of the upstreams minus one, -1 disables the retries
+	Retries int
+	// MaxFails is the failures to mark the upstream as unhealthy, defaults to 1
+	MaxFails int
+	// FailTimeout is the time that the unhealthy upstream is skipped, defaults to 10 seconds
+	FailTimeout time.Duration
+	// Transport defaults to the http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+type proxying struct {
+	balancer *proxyBalancer
+	options  ProxyOptions
+}
+
+// proxyBalancer balances the load by round robin, the failed upstreams are
+// skipped for the FailTimeout.
+type proxyBalancer struct {
+	next      uint32
+	upstreams []*proxyUpstream
+}
+
+type proxyUpstream struct {
+	lock     sync.Mutex
+	url      *url.URL
+	fails    int
+	failedAt time.Time
+}
+
+// proxyBalancers stores the balancers by the upstreams, the health states of
+// the upstreams are shared between the requests
+var proxyBalancers sync.Map
+
+var errProxyRetry = errors.New("retry the next upstream")
+
+// Proxy replies to the request with the response of the target, like
+// "http://localhost:8080", the WebSocket connections are passed through.
+func Proxy(target string, options ProxyOptions) interface{} {
+	targets := append([]string{target}, options.Upstreams...)
+	key := strings.Join(targets, ",")
+	v, ok := proxyBalancers.Load(key)
+	if !ok {
+		balancer := &proxyBalancer{}
+		for _, t := range targets {
+			u, err := url.Parse(t)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return &Error{500, "invalid proxy target: " + t}
+			}
+			balancer.upstreams = append(balancer.upstreams, &proxyUpstream{url: u})
+		}
+		v, _ = proxyBalancers.LoadOrStore(key, balancer)
+	}
+	if options.MaxFails <= 0 {
+		options.MaxFails = 1
+	}
+	if options.FailTimeout <= 0 {
+		options.FailTimeout = 10 * time.Second
+	}
+	return &proxying{v.(*proxyBalancer), options}
+}
+
+// pick returns the upstreams in order, the healthy upstreams are first.
+func (b *proxyBalancer) pick(maxFails int, failTimeout time.Duration) []*proxyUpstream {
+	n := len(b.upstreams)
+	start := int(atomic.AddUint32(&b.next, 1)-1) % n
+	healthy := make([]*proxyUpstream, 0, n)
+	var unhealthy []*proxyUpstream
+	for i := 0; i < n; i++ {
+		u := b.upstreams[(start+i)%n]
+		if u.healthy(maxFails, failTimeout) {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (u *proxyUpstream) healthy(maxFails int, failTimeout time.Duration) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.fails < maxFails || time.Since(u.failedAt) > failTimeout
+}
+
+func (u *proxyUpstream) fail() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.fails++
+	u.failedAt = time.Now()
+}
+
+func (u *proxyUpstream) ok() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.fails = 0
+}
+
+func (ctx *Context) proxy(p *proxying) {
+	upstreams := p.balancer.pick(p.options.MaxFails, p.options.FailTimeout)
+	attempts := 1
+	if (ctx.R.Method == "GET" || ctx.R.Method == "HEAD") && (ctx.R.Body == nil || ctx.R.Body == http.NoBody) {
+		retries := p.options.Retries
+		if retries == 0 {
+			retries = len(upstreams) - 1
+		}
+		if retries > 0 {
+			attempts += retries
+		}
+	}
+
+	for i := 0; i < attempts; i++ {
+		upstream := upstreams[i%len(upstreams)]
+		last := i == attempts-1
+		var proxyErr error
+		rp := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				ctx.rewriteProxyRequest(pr, upstream.url, &p.options)
+			},
+			Transport: p.options.Transport,
+			ModifyResponse: func(res *http.Response) error {
+				switch res.StatusCode {
+				case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+					upstream.fail()
+					if !last {
+						return errProxyRetry
+					}
+				default:
+					upstream.ok()
+				}
+				for _, key := range p.options.RemoveResponseHeader {
+					res.Header.Del(key)
+				}
+				for key, value := range p.options.ResponseHeader {
+					res.Header.Set(key, value)
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				proxyErr = err
+			},
+		}
+		rp.ServeHTTP(ctx.W, ctx.R)
+		if proxyErr == nil {
+			return
+		}
+		if ctx.R.Context().Err() != nil {
+			// the client is gone
+			return
+		}
+		if proxyErr != errProxyRetry {
+			upstream.fail()
+		}
+		if last {
+			if w, ok := ctx.responseWriter(); ok && w.headerSent {
+				return
+			}
+			var netErr net.Error
+			if errors.Is(proxyErr, context.DeadlineExceeded) || (errors.As(proxyErr, &netErr) && netErr.Timeout()) {
+				ctx.ejson(&Error{http.StatusGatewayTimeout, "upstream timeout"})
+			} else {
+				if ctx.logger != nil {
+					ctx.logger.Printf("[error] proxy %s: %v", upstream.url, proxyErr)
+				}
+				ctx.ejson(&Error{http.StatusBadGateway, "bad gateway"})
+			}
+			return
+		}
+	}
+}
+
+func (ctx *Context) rewriteProxyRequest(pr *httputil.ProxyRequest, target *url.URL, options *ProxyOptions) {
+	pathname := ctx.Path.String()
+	if options.Path != "" {
+		pathname = options.Path
+		if strings.Contains(pathname, "{") {
+			// the segments are decoded, the URL.Path is encoded by the transport
+			pairs := []string{"{*}", strings.TrimPrefix(ctx.Path.String(), "/")}
+			for i, segment := range ctx.Path.segments {
+				pairs = append(pairs, "{"+strconv.Itoa(i)+"}", segment)
+			}
+			pathname = strings.NewReplacer(pairs...).Replace(pathname)
+		}
+	}
+	pr.Out.URL.Path = pathname
+	pr.Out.URL.RawPath = ""
+	pr.SetURL(target)
+	if options.PreserveHost {
+		pr.Out.Host = pr.In.Host
+	}
+
+	// the forwarding headers of the trusted proxies are kept
+	h := pr.Out.Header
+	trusted := containsIP(ctx.trustedProxies, parseAddrIP(pr.In.RemoteAddr))
+	proto := "http"
+	if pr.In.TLS != nil {
+		proto = "https"
+	}
+	clientIP := ""
+	if ip := parseAddrIP(pr.In.RemoteAddr); ip != nil {
+		clientIP = ip.String()
+	}
+	if trusted {
+		if v := pr.In.Header.Values("X-Forwarded-For"); len(v) > 0 {
+			h["X-Forwarded-For"] = append([]string(nil), v...)
+		}
+		if v := pr.In.Header.Values("Forwarded"); len(v) > 0 {
+			h["Forwarded"] = append([]string(nil), v...)
+		}
+	}
+	if clientIP != "" {
+		h.Set("X-Forwarded-For", strings.Join(append(h.Values("X-Forwarded-For"), clientIP), ", "))
+	}
+	host, forwardedProto := pr.In.Host, proto
+	if trusted {
+		if v := pr.In.Header.Get("X-Forwarded-Host"); v != "" {
+			host = v
+		}
+		if v := pr.In.Header.Get("X-Forwarded-Proto"); v != "" {
+			forwardedProto = v
+		}
+	}
+	h.Set("X-Forwarded-Host", host)
+	h.Set("X-Forwarded-Proto", forwardedProto)
+	forwarded := "for=" + forwardedNode(clientIP) + ";host=" + strconv.Quote(pr.In.Host) + ";proto=" + proto
+	h.Set("Forwarded", strings.Join(append(h.Values("Forwarded"), forwarded), ", "))
+
+	for _, key := range options.RemoveRequestHeader {
+		h.Del(key)
+	}
+	for key, value := range options.RequestHeader {
+		h.Set(key, value)
+	}
+}
+
+// forwardedNode returns the node of the Forwarded header(RFC 7239), the IPv6
+// addresses are quoted.
+func forwardedNode(ip string) string {
+	if ip == "" {
+		return "unknown"
+	}
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
@@ -0,0 +1,218 @@
+package rex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newProxyTestUpstream(name string, status int, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			atomic.AddInt32(hits, 1)
+		}
+		w.Header().Set("X-Upstream", name)
+		w.Header().Set("X-Secret", "secret")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s %s", name, r.URL.EscapedPath())
+	}))
+}
+
+func proxyTestGet(h http.Handler, method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestProxyBalancing(t *testing.T) {
+	a := newProxyTestUpstream("a", 200, nil)
+	defer a.Close()
+	b := newProxyTestUpstream("b", 200, nil)
+	defer b.Close()
+
+	api := &APIHandler{}
+	api.Query("*", func(ctx *Context) interface{} {
+		return Proxy(a.URL, ProxyOptions{Upstreams: []string{b.URL}})
+	})
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		w := proxyTestGet(api, "GET", "/", nil)
+		counts[w.Header().Get("X-Upstream")]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("unbalanced: %v", counts)
+	}
+}
+
+func TestProxyPassiveHealthAndRetry(t *testing.T) {
+	var aHits, bHits int32
+	a := newProxyTestUpstream("a", 503, &aHits)
+	defer a.Close()
+	b := newProxyTestUpstream("b", 200, &bHits)
+	defer b.Close()
+
+	api := &APIHandler{}
+	api.Query("*", func(ctx *Context) interface{} {
+		return Proxy(a.URL, ProxyOptions{Upstreams: []string{b.URL}})
+	})
+	for i := 0; i < 4; i++ {
+		w := proxyTestGet(api, "GET", "/", nil)
+		if w.Code != 200 || w.Header().Get("X-Upstream") != "b" {
+			t.Fatalf("request %d: got %d from %q", i, w.Code, w.Header().Get("X-Upstream"))
+		}
+	}
+	// the failed upstream is skipped in the FailTimeout
+	if aHits != 1 || bHits != 4 {
+		t.Fatalf("hits: a=%d b=%d", aHits, bHits)
+	}
+}
+
+func TestProxyRetriesDisabled(t *testing.T) {
+	var aHits, bHits int32
+	a := newProxyTestUpstream("a", 503, &aHits)
+	defer a.Close()
+	b := newProxyTestUpstream("b", 200, &bHits)
+	defer b.Close()
+
+	api := &APIHandler{}
+	api.Query("*", func(ctx *Context) interface{} {
+		return Proxy(a.URL, ProxyOptions{Upstreams: []string{b.URL}, Retries: -1})
+	})
+	w := proxyTestGet(api, "GET", "/", nil)
+	if w.Code != 503 || aHits != 1 || bHits != 0 {
+		t.Fatalf("got %d, hits: a=%d b=%d", w.Code, aHits, bHits)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	var hits int32
+	up := newProxyTestUpstream("up", 200, &hits)
+	defer up.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	api := &APIHandler{}
+	api.Mutation("*", func(ctx *Context) interface{} {
+		return Proxy(down.URL, ProxyOptions{Upstreams: []string{up.URL}})
+	})
+	// the mutations are not retried
+	w := proxyTestGet(api, "POST", "/", nil)
+	if w.Code != 502 || hits != 0 {
+		t.Fatalf("got %d, hits %d", w.Code, hits)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"error":{"status":502,"message":"bad gateway"}}` {
+		t.Fatalf("unexpected body %s", body)
+	}
+}
+
+func TestProxyRewrite(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Secret", "secret")
+		fmt.Fprintf(w, "%s|%s|%s|%s|%s", r.URL.EscapedPath(), r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("X-Api"), r.Header.Get("X-Forwarded-For"))
+	}))
+	defer up.Close()
+
+	api := &APIHandler{}
+	api.Query("legacy/*", func(ctx *Context) interface{} {
+		return Proxy(up.URL, ProxyOptions{
+			Path:                 "/v1/items/{1}",
+			RequestHeader:        map[string]string{"X-Api": "rex"},
+			RemoveRequestHeader:  []string{"Authorization"},
+			ResponseHeader:       map[string]string{"X-Proxy": "rex"},
+			RemoveResponseHeader: []string{"X-Secret"},
+		})
+	})
+
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"/legacy/42?x=1", "/v1/items/42"},
+		{"/legacy/a%20b", "/v1/items/a%20b"},
+		{"/legacy/caf%C3%A9", "/v1/items/caf%C3%A9"},
+	}
+	for _, test := range tests {
+		w := proxyTestGet(api, "GET", test.target, map[string]string{
+			"Authorization":   "Bearer token",
+			"X-Forwarded-For": "6.6.6.6",
+		})
+		parts := strings.Split(w.Body.String(), "|")
+		if len(parts) != 5 {
+			t.Fatalf("GET %s: unexpected body %q", test.target, w.Body.String())
+		}
+		if parts[0] != test.path {
+			t.Errorf("GET %s: upstream path %q, want %q", test.target, parts[0], test.path)
+		}
+		if parts[2] != "" || parts[3] != "rex" {
+			t.Errorf("GET %s: request headers %q %q", test.target, parts[2], parts[3])
+		}
+		// the X-Forwarded-For of the untrusted client is dropped
+		if parts[4] != "192.0.2.1" {
+			t.Errorf("GET %s: X-Forwarded-For %q", test.target, parts[4])
+		}
+		if w.Header().Get("X-Secret") != "" || w.Header().Get("X-Proxy") != "rex" {
+			t.Errorf("GET %s: response headers %v", test.target, w.Header())
+		}
+	}
+	w := proxyTestGet(api, "GET", "/legacy/42?x=1", nil)
+	if parts := strings.Split(w.Body.String(), "|"); parts[1] != "x=1" {
+		t.Errorf("query %q", parts[1])
+	}
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", 426)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo " + line)
+		brw.Flush()
+	}))
+	defer up.Close()
+
+	api := &APIHandler{}
+	api.Query("ws", func(ctx *Context) interface{} {
+		return Proxy(up.URL, ProxyOptions{})
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: rex\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 101 {
+		t.Fatalf("got %d", res.StatusCode)
+	}
+	io.WriteString(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "echo hello\n" {
+		t.Fatalf("got %q %v", line, err)
+	}
+}
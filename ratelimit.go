@@ -0,0 +1,285 @@
+package rex
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitConfig contains options for the RateLimit middleware.
+type RateLimitConfig struct {
+	// Algorithm can be "token-bucket"(default) or "sliding-window"
+	Algorithm string
+	// Limit is the max requests in the Window
+	Limit int
+	// Window defaults to one minute
+	Window time.Duration
+	// Burst is the token bucket capacity, defaults to Limit
+	Burst int
+	// KeyBy can be "ip"(default), "basic-auth-user" or "session"
+	KeyBy string
+	// Key returns the key of the request, overrides the KeyBy
+	Key func(ctx *Context) string
+	// PerEndpoint counts requests per endpoint, like "post/*"
+	PerEndpoint bool
+	// Name prefixes the keys to share a store between limits
+	Name string
+	// Store defaults to a memory store that is shared by the limits
+	Store RateLimitStore
+}
+
+// RateLimitRule defines the rule to take a request.
+type RateLimitRule struct {
+	Algorithm string
+	Limit     int
+	Burst     int
+	Window    time.Duration
+}
+
+// RateLimitResult is the result of taking a request.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// A RateLimitStore interface takes requests by key, distributed backends
+// must apply the rule atomically.
+type RateLimitStore interface {
+	Take(key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+// RateLimit returns a RateLimit middleware.
+func RateLimit(config RateLimitConfig) Handle {
+	if config.Limit <= 0 {
+		panic("RateLimit: limit must be greater than 0")
+	}
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.Burst <= 0 {
+		config.Burst = config.Limit
+	}
+	if config.Store == nil {
+		config.Store = defaultRateLimitStore()
+		if config.Name == "" {
+			// separate the keys of the limits in the shared store
+			config.Name = "#" + strconv.FormatUint(atomic.AddUint64(&rateLimitSeq, 1), 10)
+		}
+	}
+	rule := RateLimitRule{
+		Algorithm: config.Algorithm,
+		Limit:     config.Limit,
+		Burst:     config.Burst,
+		Window:    config.Window,
+	}
+
+	return func(ctx *Context) interface{} {
+		var key string
+		if config.Key != nil {
+			key = config.Key(ctx)
+		} else {
+			switch config.KeyBy {
+			case "basic-auth-user":
+				key = ctx.basicAuthUser
+			case "session":
+				if ctx.sidStore.Get(ctx.R) != "" {
+					key = ctx.Session().SID()
+				}
+			}
+			if key == "" {
+				key = ctx.RemoteIP()
+			}
+		}
+		if config.PerEndpoint {
+			key = ctx.endpoint() + "|" + key
+		}
+		if config.Name != "" {
+			key = config.Name + "|" + key
+		}
+
+		ret, err := config.Store.Take(key, rule)
+		if err != nil {
+			// fail open
+			if ctx.logger != nil {
+				ctx.logger.Printf("[error] RateLimit: %v", err)
+			}
+			return nil
+		}
+
+		ctx.SetHeader("RateLimit-Limit", strconv.Itoa(ret.Limit))
+		ctx.SetHeader("RateLimit-Remaining", strconv.Itoa(ret.Remaining))
+		ctx.SetHeader("RateLimit-Reset", strconv.Itoa(int(math.Ceil(ret.Reset.Seconds()))))
+		if !ret.Allowed {
+			ctx.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(ret.RetryAfter.Seconds()))))
+			return &Error{http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests)}
+		}
+		return nil
+	}
+}
+
+type rateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+	// sliding window
+	window    time.Time
+	prevCount int
+	count     int
+
+	expires time.Time
+}
+
+type rateLimitShard struct {
+	lock    sync.Mutex
+	entries map[string]*rateLimitEntry
+}
+
+// MemoryRateLimitStore is a sharded in-memory RateLimitStore.
+type MemoryRateLimitStore struct {
+	shards []*rateLimitShard
+	done   chan struct{}
+	once   sync.Once
+}
+
+var (
+	rateLimitSeq       uint64
+	rateLimitStoreOnce sync.Once
+	rateLimitStore     *MemoryRateLimitStore
+)
+
+// defaultRateLimitStore returns the memory store that is shared by the limits
+// without a store.
+func defaultRateLimitStore() *MemoryRateLimitStore {
+	rateLimitStoreOnce.Do(func() {
+		rateLimitStore = NewMemoryRateLimitStore(16)
+	})
+	return rateLimitStore
+}
+
+// NewMemoryRateLimitStore returns a new MemoryRateLimitStore, the expired
+// entries are removed every minute until the store is closed.
+func NewMemoryRateLimitStore(shards int) *MemoryRateLimitStore {
+	if shards <= 0 {
+		shards = 1
+	}
+	store := &MemoryRateLimitStore{shards: make([]*rateLimitShard, shards), done: make(chan struct{})}
+	for i := range store.shards {
+		store.shards[i] = &rateLimitShard{entries: map[string]*rateLimitEntry{}}
+	}
+	go store.gcLoop()
+	return store
+}
+
+// Take takes a request by the key.
+func (s *MemoryRateLimitStore) Take(key string, rule RateLimitRule) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := s.shards[h.Sum32()%uint32(len(s.shards))]
+	now := time.Now()
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	e, ok := shard.entries[key]
+	if !ok {
+		e = &rateLimitEntry{tokens: float64(rule.Burst), last: now, window: now.Truncate(rule.Window)}
+		shard.entries[key] = e
+	}
+	e.expires = now.Add(2 * rule.Window)
+
+	if rule.Algorithm == "sliding-window" {
+		return e.slidingWindow(now, rule), nil
+	}
+	return e.tokenBucket(now, rule), nil
+}
+
+func (e *rateLimitEntry) tokenBucket(now time.Time, rule RateLimitRule) RateLimitResult {
+	rate := float64(rule.Limit) / rule.Window.Seconds() // tokens per second
+	e.tokens = math.Min(float64(rule.Burst), e.tokens+now.Sub(e.last).Seconds()*rate)
+	e.last = now
+
+	ret := RateLimitResult{Limit: rule.Burst}
+	if e.tokens >= 1 {
+		e.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = time.Duration((1 - e.tokens) / rate * float64(time.Second))
+	}
+	ret.Remaining = int(e.tokens)
+	ret.Reset = time.Duration((float64(rule.Burst) - e.tokens) / rate * float64(time.Second))
+	return ret
+}
+
+func (e *rateLimitEntry) slidingWindow(now time.Time, rule RateLimitRule) RateLimitResult {
+	window := now.Truncate(rule.Window)
+	if window != e.window {
+		if window.Sub(e.window) == rule.Window {
+			e.prevCount = e.count
+		} else {
+			e.prevCount = 0
+		}
+		e.count = 0
+		e.window = window
+	}
+
+	// weight the previous window by its overlap with the sliding window
+	elapsed := now.Sub(window)
+	weight := 1 - float64(elapsed)/float64(rule.Window)
+	estimated := float64(e.prevCount)*weight + float64(e.count)
+
+	ret := RateLimitResult{Limit: rule.Limit, Reset: rule.Window - elapsed}
+	if estimated+1 <= float64(rule.Limit) {
+		e.count++
+		estimated++
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = rule.Window - elapsed
+		if e.prevCount > 0 && float64(e.count) < float64(rule.Limit) {
+			// the previous window slides out until the request fits
+			need := (estimated + 1 - float64(rule.Limit)) / float64(e.prevCount)
+			ret.RetryAfter = time.Duration(need * float64(rule.Window))
+		}
+	}
+	ret.Remaining = rule.Limit - int(math.Ceil(estimated))
+	if ret.Remaining < 0 {
+		ret.Remaining = 0
+	}
+	return ret
+}
+
+// Close stops removing the expired entries of the store.
+func (s *MemoryRateLimitStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+func (s *MemoryRateLimitStore) gcLoop() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+		now := time.Now()
+		for _, shard := range s.shards {
+			shard.lock.Lock()
+			for key, e := range shard.entries {
+				if e.expires.Before(now) {
+					delete(shard.entries, key)
+				}
+			}
+			shard.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package rex
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	api := &APIHandler{}
+	api.Query("a", RateLimit(RateLimitConfig{Limit: 2}), func(ctx *Context) interface{} { return "ok" })
+	// the limits without a name don't share the keys in the default store
+	api.Query("b", RateLimit(RateLimitConfig{Limit: 1}), func(ctx *Context) interface{} { return "ok" })
+	perEndpoint := RateLimit(RateLimitConfig{Limit: 1, PerEndpoint: true})
+	api.Query("c", perEndpoint, func(ctx *Context) interface{} { return "ok" })
+	api.Query("d", perEndpoint, func(ctx *Context) interface{} { return "ok" })
+
+	tests := []struct {
+		path      string
+		ip        string
+		status    int
+		remaining string
+	}{
+		{"/a", "10.0.0.1", 200, "1"},
+		{"/a", "10.0.0.1", 200, "0"},
+		{"/a", "10.0.0.1", 429, "0"},
+		{"/a", "10.0.0.2", 200, "1"},
+		{"/b", "10.0.0.1", 200, "0"},
+		{"/b", "10.0.0.1", 429, "0"},
+		{"/c", "10.0.0.1", 200, "0"},
+		{"/c", "10.0.0.1", 429, "0"},
+		{"/d", "10.0.0.1", 200, "0"},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = test.ip + ":1234"
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status || w.Header().Get("RateLimit-Remaining") != test.remaining {
+			t.Errorf("#%d %s from %s: got %d remaining %q, want %d %q", i, test.path, test.ip, w.Code, w.Header().Get("RateLimit-Remaining"), test.status, test.remaining)
+		}
+		if (w.Header().Get("Retry-After") != "") != (test.status == 429) {
+			t.Errorf("#%d %s from %s: got Retry-After %q", i, test.path, test.ip, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		rule  RateLimitRule
+		takes []time.Duration
+		want  []bool
+	}{
+		{
+			name:  "token bucket",
+			rule:  RateLimitRule{Limit: 2, Burst: 2, Window: time.Second},
+			takes: []time.Duration{0, 0, 0, 500 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second, 2 * time.Second, 2 * time.Second},
+			want:  []bool{true, true, false, true, false, true, true, false},
+		},
+		{
+			name:  "token bucket burst",
+			rule:  RateLimitRule{Limit: 1, Burst: 3, Window: time.Second},
+			takes: []time.Duration{0, 0, 0, 0, time.Second},
+			want:  []bool{true, true, true, false, true},
+		},
+		{
+			name:  "sliding window",
+			rule:  RateLimitRule{Algorithm: "sliding-window", Limit: 2, Window: time.Second},
+			takes: []time.Duration{0, 0, 0, time.Second, 1500 * time.Millisecond, 1500 * time.Millisecond, 3 * time.Second},
+			// the previous window weights 1 at the start of the next window and 0.5 in the middle
+			want: []bool{true, true, false, false, true, false, true},
+		},
+	}
+	for _, test := range tests {
+		e := &rateLimitEntry{tokens: float64(test.rule.Burst), last: start, window: start.Truncate(test.rule.Window)}
+		for i, d := range test.takes {
+			var ret RateLimitResult
+			if test.rule.Algorithm == "sliding-window" {
+				ret = e.slidingWindow(start.Add(d), test.rule)
+			} else {
+				ret = e.tokenBucket(start.Add(d), test.rule)
+			}
+			if ret.Allowed != test.want[i] {
+				t.Errorf("%s #%d at %v: got allowed %v, want %v", test.name, i, d, ret.Allowed, test.want[i])
+			}
+			if !ret.Allowed && ret.RetryAfter <= 0 {
+				t.Errorf("%s #%d at %v: got retry after %v", test.name, i, d, ret.RetryAfter)
+			}
+		}
+	}
+}
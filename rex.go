@@ -14,6 +14,14 @@ import (
 func Serve(config ServerConfig) chan error {
 	c := make(chan error, 1)
 
+	if len(config.TrustedProxies) > 0 {
+		err := defaultAPIHanlder.SetTrustedProxies(config.TrustedProxies...)
+		if err != nil {
+			c <- fmt.Errorf("TrustedProxies: %v", err)
+			return c
+		}
+	}
+
 	if config.Port > 0 {
 		go func() {
 			serv := &http.Server{
@@ -14,6 +14,8 @@ type SIDStore interface {
 // A CookieSIDStore to store sid by http cookie
 type CookieSIDStore struct {
 	CookieName string
+	// Path is the path of the cookie, the default path of the request is used if empty
+	Path string
 }
 
 func (s *CookieSIDStore) cookieName() string {
@@ -38,6 +40,7 @@ func (s *CookieSIDStore) Put(w http.ResponseWriter, sid string) {
 	cookie := &http.Cookie{
 		Name:     s.cookieName(),
 		Value:    sid,
+		Path:     s.Path,
 		HttpOnly: true,
 	}
 	w.Header().Add("Set-Cookie", cookie.String())
@@ -0,0 +1,425 @@
+package rex
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ije/gox/utils"
+)
+
+// FSConfig contains options for the ServeFS.
+type FSConfig struct {
+	// Fallback is the file to serve if the requested file is not found
+	Fallback string
+	// Index is the index file of directories, defaults to "index.html"
+	Index string
+	// DirListing lists the files of the directories without the index file
+	DirListing bool
+	// Dotfiles is the policy of the files starting with dot like `.env`: "ignore"(404, default), "deny"(403) or "allow"
+	Dotfiles string
+	// Symlinks is the policy of the symbolic links: "follow"(default), "contained" that only follows
+	// the links inside the root, or "deny"(403)
+	Symlinks string
+	// Strict rejects the request paths with `..`, backslashes or NUL bytes, and the
+	// symbolic links that lead outside the root
+	Strict bool
+	// MaxAge is the cache max-age(in seconds) of the files
+	MaxAge int
+	// Immutable caches the fingerprinted files like `app.3f2a1b9c.js` for one
+	// year as immutable, the hash of the file name contains letters and digits
+	Immutable bool
+	// SPA serves the index(or the fallback) file for the navigation requests of missing
+	// paths(HTML5 history), the missing assets are replied with 404
+	SPA bool
+	// RuntimeConfig returns the config that is injected into the index file of
+	// SPA as `window.__RUNTIME_CONFIG__`
+	RuntimeConfig func(ctx *Context) interface{}
+}
+
+type staticFS struct {
+	fsys   fs.FS
+	config FSConfig
+}
+
+// DirFS returns a file system rooted at the directory that can read the
+// symbolic links, it's required by the Symlinks policy of the ServeFS.
+func DirFS(root string) fs.FS {
+	return &osDirFS{os.DirFS(root), root}
+}
+
+// ServeFS replies to the request with the contents of the file system, like
+// the `embed.FS`. The precompressed `.br`, `.zst` and `.gz` siblings are served if
+// the client accepts them. The "contained" and "deny" Symlinks policies require
+// a file system that can read the symbolic links like the DirFS, other file
+// systems are rejected with 500 except the `embed.FS` that has no links.
+func ServeFS(fsys fs.FS, config FSConfig) interface{} {
+	if config.Index == "" {
+		config.Index = "index.html"
+	}
+	if config.Strict && (config.Symlinks == "" || config.Symlinks == "follow") {
+		config.Symlinks = "contained"
+	}
+	if config.Symlinks != "" && config.Symlinks != "follow" {
+		_, canReadLink := fsys.(readLinkFS)
+		_, isEmbed := fsys.(embed.FS)
+		if !canReadLink && !isEmbed {
+			return &Error{500, "ServeFS: the file system can't read symbolic links, use rex.DirFS"}
+		}
+	}
+	return &staticFS{fsys, config}
+}
+
+var (
+	errSymlinkDenied  = errors.New("symlink denied")
+	errSymlinkOutside = errors.New("symlink leads outside the root")
+)
+
+// A readLinkFS is a file system that can read symbolic links, like the
+// os.DirFS since Go 1.25.
+type readLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// osDirFS is the os.DirFS with the readLinkFS methods.
+type osDirFS struct {
+	fs.FS
+	root string
+}
+
+func (d *osDirFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+func (d *osDirFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Lstat(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+// stat returns the resolved name and the file info of the name by the symlink
+// policy, the resolved name should be used to open the file.
+func (s *staticFS) stat(name string) (string, fs.FileInfo, error) {
+	if rl, ok := s.fsys.(readLinkFS); ok && s.config.Symlinks != "" && s.config.Symlinks != "follow" {
+		resolved, err := resolveSymlinks(rl, name, s.config.Symlinks == "deny")
+		if err != nil {
+			return "", nil, err
+		}
+		name = resolved
+	}
+	fi, err := fs.Stat(s.fsys, name)
+	return name, fi, err
+}
+
+// resolveSymlinks resolves the symbolic links of the name, the links that
+// lead outside the root are rejected.
+func resolveSymlinks(fsys readLinkFS, name string, deny bool) (string, error) {
+	parts := strings.Split(name, "/")
+	resolved := "."
+	for hops := 0; len(parts) > 0; {
+		p := parts[0]
+		parts = parts[1:]
+		switch p {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return "", errSymlinkOutside
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, p)
+		fi, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if deny {
+			return "", errSymlinkDenied
+		}
+		if hops++; hops > 40 {
+			return "", errSymlinkOutside
+		}
+		target, err := fsys.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) || filepath.IsAbs(target) {
+			return "", errSymlinkOutside
+		}
+		parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+	}
+	return resolved, nil
+}
+
+// hostilePath checks the request path whether contains `..`, backslashes or NUL bytes.
+func hostilePath(pathname string) bool {
+	if strings.ContainsAny(pathname, "\\\x00") {
+		return true
+	}
+	for _, s := range strings.Split(pathname, "/") {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+var fingerprintRegexp = regexp.MustCompile(`[.-]([A-Za-z0-9_]{8,})\.[A-Za-z0-9]+$`)
+
+// isFingerprinted checks the filename whether contains a content hash, like
+// `app.3f2a1b9c.js` or `index-BdXnI3Cu.js`, the dates and numbers like
+// `report-20240101.pdf` are not hashes.
+func isFingerprinted(name string) bool {
+	m := fingerprintRegexp.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "0123456789") && strings.IndexFunc(m[1], unicode.IsLetter) >= 0
+}
+
+func isDotfile(name string) bool {
+	for _, s := range strings.Split(name, "/") {
+		if strings.HasPrefix(s, ".") && s != "." {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctx *Context) serveStatic(s *staticFS) {
+	if strings.ContainsRune(ctx.R.URL.Path, 0) || (s.config.Strict && hostilePath(ctx.R.URL.Path)) {
+		ctx.ejson(&Error{400, "invalid path"})
+		return
+	}
+	name := path.Join(ctx.Path.segments...)
+	if name == "" {
+		name = "."
+	}
+	if isDotfile(name) {
+		switch s.config.Dotfiles {
+		case "allow":
+		case "deny":
+			ctx.ejson(&Error{403, "forbidden"})
+			return
+		default:
+			ctx.ejson(&Error{404, "not found"})
+			return
+		}
+	}
+
+	file, fi, err := s.stat(name)
+	if err == nil && fi.IsDir() {
+		index := path.Join(name, s.config.Index)
+		if ifile, ifi, e := s.stat(index); e == nil && !ifi.IsDir() {
+			name, file, fi = index, ifile, ifi
+		} else if s.config.DirListing {
+			ctx.listDir(s, file)
+			return
+		} else {
+			err = fs.ErrNotExist
+		}
+	}
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		if s.config.SPA {
+			if isNavigation(ctx.R, name) {
+				name = s.spaIndex()
+				file, fi, err = s.stat(name)
+			}
+		} else if s.config.Fallback != "" {
+			name = strings.TrimPrefix(utils.CleanPath(s.config.Fallback), "/")
+			file, fi, err = s.stat(name)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errSymlinkDenied) || errors.Is(err, errSymlinkOutside) {
+			ctx.ejson(&Error{403, "forbidden"})
+		} else if errors.Is(err, fs.ErrNotExist) {
+			ctx.ejson(&Error{404, "not found"})
+		} else {
+			ctx.ejson(&Error{500, err.Error()})
+		}
+		return
+	}
+	ctx.serveStaticFile(s, name, file, fi)
+}
+
+// spaIndex returns the index file of SPA.
+func (s *staticFS) spaIndex() string {
+	if s.config.Fallback != "" {
+		return strings.TrimPrefix(utils.CleanPath(s.config.Fallback), "/")
+	}
+	return s.config.Index
+}
+
+// isNavigation checks the request whether is a navigation of browsers, that
+// accepts html and the path has no file extension.
+func isNavigation(r *http.Request, name string) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	return path.Ext(name) == "" && (strings.Contains(r.Header.Get("Accept"), "text/html") || r.Header.Get("Sec-Fetch-Mode") == "navigate")
+}
+
+// injectRuntimeConfig injects the config as `window.__RUNTIME_CONFIG__` into the html head.
+func injectRuntimeConfig(html []byte, config interface{}) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	script := append(append([]byte("<script>window.__RUNTIME_CONFIG__="), data...), "</script>"...)
+	i := bytes.Index(bytes.ToLower(html), []byte("</head>"))
+	if i < 0 {
+		return append(script, html...), nil
+	}
+	return append(append(append([]byte{}, html[:i]...), script...), html[i:]...), nil
+}
+
+// serveStaticFile serves the file that is resolved from the name.
+func (ctx *Context) serveStaticFile(s *staticFS, name string, file string, fi fs.FileInfo) {
+	h := ctx.W.Header()
+	isIndex := s.config.SPA && name == s.spaIndex()
+	inject := isIndex && s.config.RuntimeConfig != nil
+	if isIndex {
+		h.Set("Cache-Control", "no-cache")
+	} else if s.config.Immutable && isFingerprinted(path.Base(name)) {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if s.config.MaxAge > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.config.MaxAge))
+	}
+
+	if h.Get("Content-Type") == "" {
+		if ctype := contentTypeByName(name); ctype != "" {
+			h.Set("Content-Type", ctype)
+		}
+	}
+	compressable := ctx.compressionConfig().compressable(h.Get("Content-Type"))
+	filename, encoding := file, ""
+	if compressable && !inject {
+		addVary(h, "Accept-Encoding")
+		for _, e := range []struct{ encoding, ext string }{{"br", ".br"}, {"zstd", ".zst"}, {"gzip", ".gz"}} {
+			if acceptsEncoding(ctx.R, e.encoding) {
+				if cfile, cfi, err := s.stat(name + e.ext); err == nil && !cfi.IsDir() {
+					filename, encoding = cfile, e.encoding
+					break
+				}
+			}
+		}
+	}
+
+	f, err := s.fsys.Open(filename)
+	if err != nil {
+		ctx.ejson(&Error{500, err.Error()})
+		return
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			ctx.ejson(&Error{500, err.Error()})
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	mtime, size := fi.ModTime(), fi.Size()
+	if inject {
+		data, err := io.ReadAll(content)
+		if err == nil {
+			data, err = injectRuntimeConfig(data, s.config.RuntimeConfig(ctx))
+		}
+		if err != nil {
+			ctx.ejson(&Error{500, err.Error()})
+			return
+		}
+		// the injected content is dynamic
+		content, mtime, size = bytes.NewReader(data), time.Time{}, int64(len(data))
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	} else if compressable {
+		ctx.compress(size)
+	}
+	http.ServeContent(ctx.W, ctx.R, path.Base(name), mtime, content)
+}
+
+var dirListingTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Index of {{.Path}}</title>
+</head>
+<body>
+  <h1>Index of {{.Path}}</h1>
+  <ul>
+    {{range .Entries}}<li><a href="{{.Href}}">{{.Name}}</a></li>
+    {{end}}
+  </ul>
+</body>
+</html>
+`))
+
+type dirEntry struct {
+	Name string
+	Href string
+}
+
+func (ctx *Context) listDir(s *staticFS, dir string) {
+	entries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		ctx.ejson(&Error{500, err.Error()})
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	pathname := utils.CleanPath(ctx.R.URL.Path)
+	list := []dirEntry{}
+	if pathname != "/" {
+		list = append(list, dirEntry{"../", path.Dir(pathname)})
+	}
+	for _, entry := range entries {
+		if s.config.Dotfiles != "allow" && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		n := entry.Name()
+		if entry.IsDir() {
+			n += "/"
+		}
+		list = append(list, dirEntry{n, path.Join(pathname, entry.Name())})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := dirListingTemplate.Execute(buf, map[string]interface{}{"Path": pathname, "Entries": list}); err != nil {
+		ctx.ejson(&Error{500, err.Error()})
+		return
+	}
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+	ctx.W.Write(buf.Bytes())
+}
@@ -0,0 +1,170 @@
+package rex
+
+import (
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStaticTestRoot(t *testing.T) string {
+	root := t.TempDir()
+	outside := t.TempDir()
+	files := map[string]string{
+		"index.html":     "<h1>index</h1>",
+		"app.js":         "console.log(1)",
+		".env":           "SECRET=1",
+		".git/config":    "[core]",
+		"assets/app.css": "body{}",
+	}
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"outside.txt": filepath.Join(outside, "secret.txt"),
+		"escape":      filepath.Join("..", filepath.Base(outside)),
+		"inside.js":   "app.js",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks are not supported:", err)
+		}
+	}
+	return root
+}
+
+func serveStaticTest(api *APIHandler, target string) (int, string) {
+	r := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestFSHostilePaths(t *testing.T) {
+	root := newStaticTestRoot(t)
+	api := &APIHandler{}
+	api.Query("*", func(ctx *Context) interface{} {
+		return FSWithConfig(root, FSConfig{Strict: true})
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/app.js", 200},
+		{"/assets/app.css", 200},
+		{"/inside.js", 200},
+		{"/../secret.txt", 400},
+		{"/assets/../../secret.txt", 400},
+		{"/%2e%2e/secret.txt", 400},
+		{"/assets/%2E%2E/%2e%2e/secret.txt", 400},
+		{"/assets%5C..%5Capp.js", 400},
+		{"/app.js%00.png", 400},
+		{"/outside.txt", 403},
+		{"/escape/secret.txt", 403},
+		{"/.env", 404},
+		{"/.git/config", 404},
+	}
+	for _, test := range tests {
+		status, body := serveStaticTest(api, test.path)
+		if status != test.status {
+			t.Errorf("GET %q: got %d %s, want %d", test.path, status, body, test.status)
+		}
+		if body == "secret" || body == "SECRET=1" {
+			t.Errorf("GET %q: leaked %q", test.path, body)
+		}
+	}
+}
+
+func TestFSPolicies(t *testing.T) {
+	root := newStaticTestRoot(t)
+	tests := []struct {
+		config FSConfig
+		path   string
+		status int
+	}{
+		{FSConfig{}, "/outside.txt", 200},
+		{FSConfig{Symlinks: "contained"}, "/outside.txt", 403},
+		{FSConfig{Symlinks: "contained"}, "/inside.js", 200},
+		{FSConfig{Symlinks: "deny"}, "/inside.js", 403},
+		{FSConfig{Dotfiles: "deny"}, "/.env", 403},
+		{FSConfig{Dotfiles: "allow"}, "/.env", 200},
+	}
+	for _, test := range tests {
+		config := test.config
+		api := &APIHandler{}
+		api.Query("*", func(ctx *Context) interface{} {
+			return FSWithConfig(root, config)
+		})
+		status, body := serveStaticTest(api, test.path)
+		if status != test.status {
+			t.Errorf("%+v GET %q: got %d %s, want %d", config, test.path, status, body, test.status)
+		}
+	}
+}
+
+func TestServeFSFailsClosed(t *testing.T) {
+	root := newStaticTestRoot(t)
+	api := &APIHandler{}
+	api.Query("*", func(ctx *Context) interface{} {
+		return ServeFS(linkUnawareFS{os.DirFS(root)}, FSConfig{Strict: true})
+	})
+	for _, p := range []string{"/outside.txt", "/app.js"} {
+		if status, body := serveStaticTest(api, p); status != 500 {
+			t.Errorf("GET %q: got %d %s, want 500", p, status, body)
+		}
+	}
+}
+
+// linkUnawareFS hides the ReadLink and Lstat methods of the file system.
+type linkUnawareFS struct {
+	fs.FS
+}
+
+func TestFSCacheControl(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"index.html", "app.3f2a1b9c.js", "index-BdXnI3Cu.js", "report-20240101.pdf", "avatar-12345678.png"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	immutable := "public, max-age=31536000, immutable"
+	tests := []struct {
+		config       FSConfig
+		path         string
+		cacheControl string
+	}{
+		{FSConfig{}, "/app.3f2a1b9c.js", ""},
+		{FSConfig{MaxAge: 60}, "/app.3f2a1b9c.js", "public, max-age=60"},
+		{FSConfig{Immutable: true}, "/app.3f2a1b9c.js", immutable},
+		{FSConfig{Immutable: true}, "/index-BdXnI3Cu.js", immutable},
+		{FSConfig{Immutable: true}, "/report-20240101.pdf", ""},
+		{FSConfig{Immutable: true, MaxAge: 60}, "/report-20240101.pdf", "public, max-age=60"},
+		{FSConfig{Immutable: true}, "/avatar-12345678.png", ""},
+		{FSConfig{Immutable: true, SPA: true}, "/", "no-cache"},
+		{FSConfig{Immutable: true, SPA: true}, "/app.3f2a1b9c.js", immutable},
+	}
+	for _, test := range tests {
+		config := test.config
+		api := &APIHandler{}
+		api.Query("*", func(ctx *Context) interface{} {
+			return FSWithConfig(root, config)
+		})
+		r := httptest.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if got := w.Header().Get("Cache-Control"); w.Code != 200 || got != test.cacheControl {
+			t.Errorf("%+v GET %q: got %d %q, want %q", config, test.path, w.Code, got, test.cacheControl)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package rex
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplatesConfig contains options for the Templates.
+type TemplatesConfig struct {
+	// Dir is the directory of the templates, ignored if the FS is set
+	Dir string
+	FS  fs.FS
+	// Ext is the extension of the template files, defaults to ".html"
+	Ext string
+	// Layout is the default layout of the pages, like "layouts/base"
+	Layout string
+	// LayoutsDir defaults to "layouts"
+	LayoutsDir string
+	// PartialsDir defaults to "partials", the partials are available in all the pages
+	PartialsDir string
+	FuncMap     template.FuncMap
+	// Reload reloads the templates when the files are changed, for development
+	Reload bool
+}
+
+// Templates is a template engine that renders the pages with layouts and partials.
+// A page is named by its path without the extension, like "pages/home", and
+// can choose the layout by a comment at the beginning:
+//
+//	{{/* layout: layouts/admin */}}
+//	{{define "content"}}...{{end}}
+//
+// The layout renders the blocks of the page by `{{block "content" .}}{{end}}`.
+// The templates are executed with a *TemplateData, the data of the Render are
+// available by `{{.Data}}` and the request data by `{{.Request}}`,
+// `{{.CurrentUser}}` and `{{.CSRFToken}}`.
+type Templates struct {
+	lock      sync.RWMutex
+	config    TemplatesConfig
+	fsys      fs.FS
+	pages     map[string]*templatePage
+	signature string
+	checkedAt time.Time
+}
+
+type templatePage struct {
+	t      *template.Template
+	layout string
+}
+
+type rendering struct {
+	name string
+	data interface{}
+}
+
+// TemplateData is the data of the templates that wraps the data of the Render
+// with the request data.
+type TemplateData struct {
+	Data interface{}
+	ctx  *Context
+}
+
+// Ctx returns the context of the request.
+func (d *TemplateData) Ctx() *Context {
+	return d.ctx
+}
+
+// Request returns the http request.
+func (d *TemplateData) Request() *http.Request {
+	return d.ctx.R
+}
+
+// CurrentUser returns the user of the ACL middleware, or nil.
+func (d *TemplateData) CurrentUser() ACLUser {
+	return d.ctx.aclUser
+}
+
+// CSRFToken returns the CSRF token that is stored in the session.
+func (d *TemplateData) CSRFToken() string {
+	return d.ctx.CSRFToken()
+}
+
+var layoutCommentRegexp = regexp.MustCompile(`^\s*\{\{/\*\s*layout:\s*(\S+)\s*\*/\}\}`)
+
+// NewTemplates loads the templates.
+func NewTemplates(config TemplatesConfig) (*Templates, error) {
+	if config.Ext == "" {
+		config.Ext = ".html"
+	}
+	if config.LayoutsDir == "" {
+		config.LayoutsDir = "layouts"
+	}
+	if config.PartialsDir == "" {
+		config.PartialsDir = "partials"
+	}
+	fsys := config.FS
+	if fsys == nil {
+		if config.Dir == "" {
+			return nil, fmt.Errorf("templates: missing Dir or FS")
+		}
+		fsys = os.DirFS(config.Dir)
+	}
+	t := &Templates{config: config, fsys: fsys}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Handle is a middleware that uses the templates for the Render.
+func (t *Templates) Handle(ctx *Context) interface{} {
+	ctx.templates = t
+	return nil
+}
+
+// Render replies to the request with the rendered page of the Templates middleware.
+func Render(name string, data interface{}) interface{} {
+	return &rendering{name, data}
+}
+
+func (t *Templates) load() error {
+	var files []string
+	var partials []string
+	var signature strings.Builder
+	err := fs.WalkDir(t.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != t.config.Ext {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			fmt.Fprintf(&signature, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+		}
+		if strings.HasPrefix(name, t.config.PartialsDir+"/") {
+			partials = append(partials, name)
+		} else {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sources := map[string]string{}
+	for _, name := range append(files, partials...) {
+		data, err := fs.ReadFile(t.fsys, name)
+		if err != nil {
+			return err
+		}
+		sources[name] = string(data)
+	}
+	templateName := func(file string) string {
+		return strings.TrimSuffix(file, t.config.Ext)
+	}
+
+	// the partials are parsed once and cloned for each page
+	base := template.New("").Funcs(t.config.FuncMap)
+	for _, partial := range partials {
+		if _, err := base.New(templateName(partial)).Parse(sources[partial]); err != nil {
+			return err
+		}
+	}
+
+	pages := map[string]*templatePage{}
+	for _, file := range files {
+		name := templateName(file)
+		if strings.HasPrefix(file, t.config.LayoutsDir+"/") {
+			continue
+		}
+		layout := t.config.Layout
+		if m := layoutCommentRegexp.FindStringSubmatch(sources[file]); m != nil {
+			layout = m[1]
+		}
+		if layout == "none" {
+			layout = ""
+		}
+
+		tpl, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if layout != "" {
+			source, ok := sources[layout+t.config.Ext]
+			if !ok {
+				return fmt.Errorf("templates: layout '%s' of '%s' not found", layout, name)
+			}
+			if _, err := tpl.New(layout).Parse(source); err != nil {
+				return err
+			}
+		}
+		if _, err := tpl.New(name).Parse(sources[file]); err != nil {
+			return err
+		}
+		pages[name] = &templatePage{tpl, layout}
+	}
+
+	t.lock.Lock()
+	t.pages = pages
+	t.signature = signature.String()
+	t.checkedAt = time.Now()
+	t.lock.Unlock()
+	return nil
+}
+
+// reload reloads the templates if the files are changed, the files are
+// checked at most once per second.
+func (t *Templates) reload() error {
+	t.lock.RLock()
+	checked := time.Since(t.checkedAt) < time.Second
+	signature := t.signature
+	t.lock.RUnlock()
+	if checked {
+		return nil
+	}
+
+	var current strings.Builder
+	fs.WalkDir(t.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && path.Ext(name) == t.config.Ext {
+			if fi, err := d.Info(); err == nil {
+				fmt.Fprintf(&current, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+			}
+		}
+		return nil
+	})
+	if current.String() == signature {
+		t.lock.Lock()
+		t.checkedAt = time.Now()
+		t.lock.Unlock()
+		return nil
+	}
+	return t.load()
+}
+
+// Render renders the page to the buffer with the request data of the context.
+func (t *Templates) Render(ctx *Context, name string, data interface{}) ([]byte, error) {
+	if t.config.Reload {
+		if err := t.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	t.lock.RLock()
+	page, ok := t.pages[name]
+	t.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("template '%s' not found", name)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	entry := name
+	if page.layout != "" {
+		entry = page.layout
+	}
+	if err := page.t.ExecuteTemplate(buf, entry, &TemplateData{Data: data, ctx: ctx}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (ctx *Context) render(r *rendering, status int) {
+	if ctx.templates == nil {
+		ctx.ejson(&Error{500, "templates not found, use the Templates.Handle middleware"})
+		return
+	}
+	html, err := ctx.templates.Render(ctx, r.name, r.data)
+	if err != nil {
+		ctx.ejson(&Error{500, err.Error()})
+		return
+	}
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+	ctx.compress(int64(len(html)))
+	if status >= 100 {
+		ctx.W.WriteHeader(status)
+	}
+	ctx.W.Write(html)
+}
+
+// CSRFToken returns the CSRF token that is stored in the session.
+func (ctx *Context) CSRFToken() string {
+	sess := ctx.Session()
+	token := sess.Get("csrf_token")
+	if len(token) == 0 {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			panic(&recoverError{500, err.Error()})
+		}
+		token = []byte(base64.RawURLEncoding.EncodeToString(buf))
+		sess.Set("csrf_token", token)
+	}
+	return string(token)
+}
@@ -0,0 +1,97 @@
+package rex
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTemplatesLayouts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html":  {Data: []byte(`<base>{{block "content" .}}{{end}}</base>`)},
+		"layouts/admin.html": {Data: []byte(`<admin>{{template "partials/nav" .}}{{block "content" .}}{{end}}</admin>`)},
+		"partials/nav.html":  {Data: []byte(`<nav>{{.Request.URL.Path}}</nav>`)},
+		"pages/home.html":    {Data: []byte(`{{define "content"}}home {{.Data.Title}}{{end}}`)},
+		"pages/admin.html":   {Data: []byte("{{/* layout: layouts/admin */}}\n" + `{{define "content"}}admin {{.Data.Title}}{{end}}`)},
+		"pages/raw.html":     {Data: []byte("{{/* layout: none */}}\n" + `raw {{.Data.Title}}{{template "partials/nav" .}}`)},
+	}
+	templates, err := NewTemplates(TemplatesConfig{FS: fsys, Layout: "layouts/base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &APIHandler{}
+	api.Use(templates.Handle)
+	api.Query("*", func(ctx *Context) interface{} {
+		return Render("pages"+ctx.Path.String(), map[string]string{"Title": "<title>"})
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/home", status: 200, body: "<base>home &lt;title&gt;</base>"},
+		{path: "/admin", status: 200, body: "<admin><nav>/admin</nav>admin &lt;title&gt;</admin>"},
+		{path: "/raw", status: 200, body: "raw &lt;title&gt;<nav>/raw</nav>"},
+		{path: "/missing", status: 500},
+	}
+	// render twice to use the executed templates again
+	for i := 0; i < 2; i++ {
+		for _, test := range tests {
+			r := httptest.NewRequest("GET", test.path, nil)
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("%s: got %d %s, want %d", test.path, w.Code, w.Body.String(), test.status)
+				continue
+			}
+			if test.body != "" && strings.TrimSpace(w.Body.String()) != test.body {
+				t.Errorf("%s: got %q, want %q", test.path, w.Body.String(), test.body)
+			}
+		}
+	}
+
+	if _, err := NewTemplates(TemplatesConfig{FS: fsys, Layout: "layouts/missing"}); err == nil {
+		t.Error("missing layout: expected an error")
+	}
+}
+
+func TestTemplatesReload(t *testing.T) {
+	mtime := time.Now()
+	fsys := fstest.MapFS{
+		"home.html": {Data: []byte(`v1`), ModTime: mtime},
+	}
+	for _, reload := range []bool{false, true} {
+		fsys["home.html"] = &fstest.MapFile{Data: []byte(`v1`), ModTime: mtime}
+		templates, err := NewTemplates(TemplatesConfig{FS: fsys, Reload: reload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := &Context{}
+		if html, err := templates.Render(ctx, "home", nil); err != nil || string(html) != "v1" {
+			t.Fatalf("reload %v: got %q %v", reload, html, err)
+		}
+
+		fsys["home.html"] = &fstest.MapFile{Data: []byte(`v2`), ModTime: mtime.Add(time.Second)}
+		fsys["about.html"] = &fstest.MapFile{Data: []byte(`about`), ModTime: mtime}
+		// the files are checked at most once per second
+		if html, err := templates.Render(ctx, "home", nil); err != nil || string(html) != "v1" {
+			t.Fatalf("reload %v: got %q %v", reload, html, err)
+		}
+		templates.checkedAt = time.Time{}
+
+		want := "v1"
+		if reload {
+			want = "v2"
+		}
+		if html, err := templates.Render(ctx, "home", nil); err != nil || string(html) != want {
+			t.Fatalf("reload %v: got %q %v, want %q", reload, html, err, want)
+		}
+		if _, err := templates.Render(ctx, "about", nil); (err == nil) != reload {
+			t.Fatalf("reload %v: got %v for the new page", reload, err)
+		}
+		delete(fsys, "about.html")
+	}
+}
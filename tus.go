@@ -0,0 +1,519 @@
+package rex
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+)
+
+const tusVersion = "1.0.0"
+
+var (
+	// ErrTusUploadNotFound is returned by the TusStore if the upload does not exist.
+	ErrTusUploadNotFound = errors.New("upload not found")
+	// ErrTusChecksumMismatch is returned by the chunk reader if the checksum
+	// mismatches, the TusStore must discard the chunk.
+	ErrTusChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// TusConfig contains options for the tus resumable upload endpoint.
+type TusConfig struct {
+	// Store defaults to a DiskTusStore in the "./uploads" directory
+	Store TusStore
+	// MaxSize is the max bytes of an upload
+	MaxSize int64
+	// Expiration is the lifetime of incomplete uploads
+	Expiration time.Duration
+	// OnComplete is called inside the rex Context when an upload is completed,
+	// the returned error is sent to the client.
+	OnComplete func(ctx *Context, upload *TusUpload) error
+}
+
+// TusUpload contains the info of an upload.
+type TusUpload struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// A TusStore interface to store the tus uploads.
+type TusStore interface {
+	// Create creates a new upload.
+	Create(upload *TusUpload) error
+	// Get returns the upload with the current offset.
+	Get(id string) (*TusUpload, error)
+	// WriteChunk appends the chunk at the offset, it keeps the written bytes if the
+	// reader fails, except the ErrTusChecksumMismatch which discards the chunk.
+	WriteChunk(id string, offset int64, r io.Reader) (int64, error)
+	// Open opens the upload content.
+	Open(id string) (io.ReadCloser, error)
+	// Terminate removes the upload.
+	Terminate(id string) error
+}
+
+// tusStatus replies the status without a body, the tus responses have no body.
+type tusStatus int
+
+type tusHandler struct {
+	config TusConfig
+	depth  int
+	lock   sync.Mutex
+	locks  map[string]struct{}
+}
+
+// Tus registers a tus 1.0 resumable upload endpoint, the uploads are located
+// at "endpoint/:id". The middlewares(like ACL) run before the tus handler.
+func (a *APIHandler) Tus(endpoint string, config TusConfig, middlewares ...Handle) {
+	endpoint = utils.CleanPath(endpoint)[1:]
+	if endpoint == "" {
+		return
+	}
+	if config.Store == nil {
+		config.Store = &DiskTusStore{Dir: "./uploads"}
+	}
+	h := &tusHandler{
+		config: config,
+		depth:  len(strings.Split(endpoint, "/")),
+		locks:  map[string]struct{}{},
+	}
+	if a.resources == nil {
+		a.resources = map[string][]Handle{}
+	}
+	for _, p := range []string{endpoint, endpoint + "/*"} {
+		for _, handle := range middlewares {
+			if handle != nil {
+				a.resources[p] = append(a.resources[p], handle)
+			}
+		}
+		a.resources[p] = append(a.resources[p], h.handle)
+	}
+}
+
+// Tus registers a tus resumable upload endpoint to the default APIHandler.
+func Tus(endpoint string, config TusConfig, middlewares ...Handle) {
+	defaultAPIHanlder.Tus(endpoint, config, middlewares...)
+}
+
+func (h *tusHandler) handle(ctx *Context) interface{} {
+	method := ctx.R.Method
+	if m := ctx.R.Header.Get("X-HTTP-Method-Override"); m != "" && method == "POST" {
+		method = strings.ToUpper(m)
+	}
+
+	header := ctx.W.Header()
+	header.Set("Tus-Resumable", tusVersion)
+	header.Set("Cache-Control", "no-store")
+
+	if method == "OPTIONS" {
+		header.Set("Tus-Version", tusVersion)
+		header.Set("Tus-Extension", "creation,creation-with-upload,expiration,checksum,termination")
+		header.Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+		if h.config.MaxSize > 0 {
+			header.Set("Tus-Max-Size", strconv.FormatInt(h.config.MaxSize, 10))
+		}
+		return tusStatus(http.StatusNoContent)
+	}
+	if ctx.R.Header.Get("Tus-Resumable") != tusVersion {
+		header.Set("Tus-Version", tusVersion)
+		return tusStatus(http.StatusPreconditionFailed)
+	}
+
+	segments := ctx.Path.segments
+	if len(segments) <= h.depth {
+		if method != "POST" {
+			return &Error{http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)}
+		}
+		return h.create(ctx)
+	}
+
+	id := segments[len(segments)-1]
+	switch method {
+	case "HEAD":
+		upload, err := h.getUpload(id)
+		if err != nil {
+			return err
+		}
+		h.setUploadHeaders(ctx, upload)
+		header.Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		if len(upload.Metadata) > 0 {
+			header.Set("Upload-Metadata", encodeTusMetadata(upload.Metadata))
+		}
+		return tusStatus(http.StatusOK)
+	case "PATCH":
+		return h.patch(ctx, id)
+	case "DELETE":
+		if !h.acquire(id) {
+			return &Error{http.StatusLocked, "upload is locked"}
+		}
+		defer h.release(id)
+		if _, err := h.getUpload(id); err != nil {
+			return err
+		}
+		if err := h.config.Store.Terminate(id); err != nil {
+			return err
+		}
+		return tusStatus(http.StatusNoContent)
+	default:
+		return &Error{http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)}
+	}
+}
+
+func (h *tusHandler) create(ctx *Context) interface{} {
+	size, err := strconv.ParseInt(ctx.R.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return &Error{400, "invalid Upload-Length"}
+	}
+	if h.config.MaxSize > 0 && size > h.config.MaxSize {
+		return &Error{http.StatusRequestEntityTooLarge, "upload too large"}
+	}
+	metadata, err := decodeTusMetadata(ctx.R.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return &Error{400, "invalid Upload-Metadata"}
+	}
+
+	upload := &TusUpload{
+		ID:       rs.Hex.String(32),
+		Size:     size,
+		Metadata: metadata,
+	}
+	if h.config.Expiration > 0 {
+		upload.Expires = time.Now().Add(h.config.Expiration).UTC()
+	}
+	if err := h.config.Store.Create(upload); err != nil {
+		return err
+	}
+	ctx.SetHeader("Location", path.Join(ctx.R.URL.Path, upload.ID))
+
+	// creation-with-upload
+	if ctx.R.Header.Get("Content-Type") == "application/offset+octet-stream" && ctx.R.ContentLength != 0 {
+		ctx.R.Header.Set("Upload-Offset", "0")
+		v := h.patch(ctx, upload.ID)
+		if v == tusStatus(http.StatusNoContent) {
+			return tusStatus(http.StatusCreated)
+		}
+		return v
+	}
+	if size == 0 {
+		if v := h.complete(ctx, upload); v != nil {
+			return v
+		}
+	}
+	h.setUploadHeaders(ctx, upload)
+	return tusStatus(http.StatusCreated)
+}
+
+func (h *tusHandler) patch(ctx *Context, id string) interface{} {
+	if ctx.R.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return &Error{http.StatusUnsupportedMediaType, http.StatusText(http.StatusUnsupportedMediaType)}
+	}
+	offset, err := strconv.ParseInt(ctx.R.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return &Error{400, "invalid Upload-Offset"}
+	}
+
+	if !h.acquire(id) {
+		return &Error{http.StatusLocked, "upload is locked"}
+	}
+	defer h.release(id)
+
+	upload, err := h.getUpload(id)
+	if err != nil {
+		return err
+	}
+	if offset != upload.Offset {
+		return &Error{http.StatusConflict, "mismatched Upload-Offset"}
+	}
+
+	var body io.Reader = io.LimitReader(ctx.R.Body, upload.Size-upload.Offset)
+	if v := ctx.R.Header.Get("Upload-Checksum"); v != "" {
+		body, err = newTusChecksumReader(body, v)
+		if err != nil {
+			return &Error{400, err.Error()}
+		}
+	}
+	n, err := h.config.Store.WriteChunk(id, offset, body)
+	if err == ErrTusChecksumMismatch {
+		return &Error{460, err.Error()}
+	}
+	upload.Offset += n
+	if err != nil && upload.Offset < upload.Size {
+		return err
+	}
+	if upload.Offset == upload.Size {
+		if v := h.complete(ctx, upload); v != nil {
+			return v
+		}
+	}
+	h.setUploadHeaders(ctx, upload)
+	return tusStatus(http.StatusNoContent)
+}
+
+func (h *tusHandler) complete(ctx *Context, upload *TusUpload) interface{} {
+	if h.config.OnComplete != nil {
+		if err := h.config.OnComplete(ctx, upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *tusHandler) getUpload(id string) (*TusUpload, error) {
+	upload, err := h.config.Store.Get(id)
+	if err == ErrTusUploadNotFound {
+		return nil, &Error{404, err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !upload.Expires.IsZero() && upload.Offset < upload.Size && upload.Expires.Before(time.Now()) {
+		h.config.Store.Terminate(id)
+		return nil, &Error{http.StatusGone, "upload expired"}
+	}
+	return upload, nil
+}
+
+func (h *tusHandler) setUploadHeaders(ctx *Context, upload *TusUpload) {
+	ctx.SetHeader("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if !upload.Expires.IsZero() && upload.Offset < upload.Size {
+		ctx.SetHeader("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+	}
+}
+
+func (h *tusHandler) acquire(id string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.locks[id]; ok {
+		return false
+	}
+	h.locks[id] = struct{}{}
+	return true
+}
+
+func (h *tusHandler) release(id string) {
+	h.lock.Lock()
+	delete(h.locks, id)
+	h.lock.Unlock()
+}
+
+type tusChecksumReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	checksum []byte
+}
+
+func newTusChecksumReader(r io.Reader, value string) (*tusChecksumReader, error) {
+	algorithm, encoded := utils.SplitByFirstByte(value, ' ')
+	checksum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid Upload-Checksum")
+	}
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, errors.New("unsupported checksum algorithm")
+	}
+	return &tusChecksumReader{r, h, checksum}, nil
+}
+
+func (c *tusChecksumReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && string(c.hash.Sum(nil)) != string(c.checksum) {
+		err = ErrTusChecksumMismatch
+	}
+	return
+}
+
+func decodeTusMetadata(value string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded := utils.SplitByFirstByte(pair, ' ')
+		v, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(v)
+	}
+	return metadata, nil
+}
+
+func encodeTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// DiskTusStore stores the tus uploads in the local Dir.
+type DiskTusStore struct {
+	Dir string
+}
+
+func (s *DiskTusStore) filename(id string, ext string) (string, error) {
+	// the id is generated by hex chars
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", ErrTusUploadNotFound
+		}
+	}
+	return filepath.Join(s.Dir, id+ext), nil
+}
+
+// Create creates a new upload.
+func (s *DiskTusStore) Create(upload *TusUpload) error {
+	infoFile, err := s.filename(upload.ID, ".info")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(infoFile[:len(infoFile)-5], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.WriteFile(infoFile, data, 0644)
+}
+
+// Get returns the upload with the current offset.
+func (s *DiskTusStore) Get(id string) (*TusUpload, error) {
+	infoFile, err := s.filename(id, ".info")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(infoFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, err
+	}
+	var upload TusUpload
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(infoFile[:len(infoFile)-5])
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, err
+	}
+	upload.Offset = fi.Size()
+	return &upload, nil
+}
+
+// WriteChunk appends the chunk at the offset.
+func (s *DiskTusStore) WriteChunk(id string, offset int64, r io.Reader) (int64, error) {
+	filename, err := s.filename(id, "")
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrTusUploadNotFound
+		}
+		return 0, err
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == ErrTusChecksumMismatch {
+		f.Truncate(offset)
+		return 0, err
+	}
+	return n, err
+}
+
+// Open opens the upload content.
+func (s *DiskTusStore) Open(id string) (io.ReadCloser, error) {
+	filename, err := s.filename(id, "")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	if err != nil && os.IsNotExist(err) {
+		return nil, ErrTusUploadNotFound
+	}
+	return f, err
+}
+
+// Terminate removes the upload.
+func (s *DiskTusStore) Terminate(id string) error {
+	filename, err := s.filename(id, "")
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename + ".info")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the expired incomplete uploads.
+func (s *DiskTusStore) Cleanup() error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".info") {
+			continue
+		}
+		upload, err := s.Get(strings.TrimSuffix(name, ".info"))
+		if err != nil {
+			continue
+		}
+		if !upload.Expires.IsZero() && upload.Offset < upload.Size && upload.Expires.Before(now) {
+			s.Terminate(upload.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package rex
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTusOffsets(t *testing.T) {
+	api := &APIHandler{}
+	api.Tus("files", TusConfig{Store: &DiskTusStore{Dir: t.TempDir()}})
+
+	var location string
+	tests := []struct {
+		method string
+		path   string
+		header map[string]string
+		body   string
+		status int
+		offset string
+	}{
+		{method: "OPTIONS", path: "/files", status: 204},
+		{method: "POST", path: "/files", header: map[string]string{"Upload-Length": "11"}, status: 412},
+		{method: "POST", path: "/files", header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Length": "-1"}, status: 400},
+		{method: "POST", path: "/files", header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Length": "11"}, status: 201, offset: "0"},
+		{method: "HEAD", path: "{location}", header: map[string]string{"Tus-Resumable": "1.0.0"}, status: 200, offset: "0"},
+		{
+			method: "PATCH",
+			path:   "{location}",
+			header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"},
+			body:   "hello",
+			status: 204,
+			offset: "5",
+		},
+		{
+			method: "PATCH",
+			path:   "{location}",
+			header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"},
+			body:   "x",
+			status: 409,
+		},
+		{
+			method: "PATCH",
+			path:   "{location}",
+			header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Offset": "5", "Content-Type": "text/plain"},
+			body:   "x",
+			status: 415,
+		},
+		{
+			method: "PATCH",
+			path:   "{location}",
+			header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Offset": "5", "Content-Type": "application/offset+octet-stream"},
+			// the bytes beyond the Upload-Length are ignored
+			body:   " world!!",
+			status: 204,
+			offset: "11",
+		},
+		{method: "HEAD", path: "{location}", header: map[string]string{"Tus-Resumable": "1.0.0"}, status: 200, offset: "11"},
+		{method: "DELETE", path: "{location}", header: map[string]string{"Tus-Resumable": "1.0.0"}, status: 204},
+		{method: "HEAD", path: "{location}", header: map[string]string{"Tus-Resumable": "1.0.0"}, status: 404},
+		// creation-with-upload
+		{
+			method: "POST",
+			path:   "/files",
+			header: map[string]string{"Tus-Resumable": "1.0.0", "Upload-Length": "5", "Content-Type": "application/offset+octet-stream"},
+			body:   "abc",
+			status: 201,
+			offset: "3",
+		},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest(test.method, strings.Replace(test.path, "{location}", location, 1), strings.NewReader(test.body))
+		for key, value := range test.header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("#%d %s %s: got %d %s, want %d", i, test.method, test.path, w.Code, w.Body.String(), test.status)
+		}
+		if test.offset != "" && w.Header().Get("Upload-Offset") != test.offset {
+			t.Fatalf("#%d %s %s: got offset %q, want %q", i, test.method, test.path, w.Header().Get("Upload-Offset"), test.offset)
+		}
+		if w.Code < 400 && w.Body.Len() > 0 {
+			t.Fatalf("#%d %s %s: got body %q", i, test.method, test.path, w.Body.String())
+		}
+		if loc := w.Header().Get("Location"); loc != "" {
+			location = loc
+		}
+	}
+}
+
+func TestStatusNilPayload(t *testing.T) {
+	api := &APIHandler{}
+	api.Query("status", func(ctx *Context) interface{} {
+		return Status(202, nil)
+	})
+	r := httptest.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 202 || strings.TrimSpace(w.Body.String()) != "null" {
+		t.Fatalf("got %d %q, want 202 null", w.Code, w.Body.String())
+	}
+}
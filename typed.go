@@ -0,0 +1,126 @@
+package rex
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"reflect"
+	"strings"
+)
+
+// A Validator interface contains the Validate method that checks the decoded request.
+type Validator interface {
+	Validate() error
+}
+
+// Typed returns a Handle that decodes the request into Req and replies the
+// returned Resp:
+//
+//	type GetUser struct {
+//		ID int `path:"1"`
+//	}
+//	rex.Query("user/*", rex.Typed(func(ctx *rex.Context, req GetUser) (*User, error) {
+//		return users.Get(req.ID)
+//	}))
+//
+// The request is decoded from the JSON body of mutations if the content type
+// is `application/json`, then from the `form`, `path` and `header` tags, the
+// fields filled by the JSON body are not decoded from the form. Then it's
+// checked by the Validate method if Req implements the Validator interface.
+// The Req can be a pointer to struct, it's allocated before decoding.
+// The invalid request is replied with 400, the returned *Error is replied as
+// it is, and other errors are replied with 500.
+func Typed[Req any, Resp any](handle func(ctx *Context, req Req) (Resp, error)) Handle {
+	return func(ctx *Context) interface{} {
+		var req Req
+		if rt := reflect.TypeOf(req); rt != nil && rt.Kind() == reflect.Ptr {
+			req = reflect.New(rt.Elem()).Interface().(Req)
+		}
+
+		form := ctx.Form.source()
+		if ctx.R.Method == "POST" && ctx.R.Body != nil {
+			if mediaType, _, _ := mime.ParseMediaType(ctx.R.Header.Get("Content-Type")); mediaType == "application/json" {
+				var body json.RawMessage
+				err := json.NewDecoder(ctx.R.Body).Decode(&body)
+				if err == nil {
+					err = json.Unmarshal(body, &req)
+				}
+				if err != nil {
+					if e, ok := err.(*Error); ok {
+						// like the 413 error of the BodyLimit middleware
+						return e
+					}
+					return &Error{400, "invalid json body: " + err.Error()}
+				}
+				var object map[string]json.RawMessage
+				if json.Unmarshal(body, &object) == nil {
+					form.filled = map[string]bool{}
+					jsonFields(reflect.TypeOf(req), object, form.filled)
+				}
+			}
+		}
+		if rt := reflect.TypeOf(req); rt != nil && (rt.Kind() == reflect.Struct || (rt.Kind() == reflect.Ptr && rt.Elem().Kind() == reflect.Struct)) {
+			// decode through the pointer
+			if err := decode(&req, form, ctx.Path.source(), headerSource(ctx.R.Header)); err != nil {
+				return err
+			}
+		}
+		if v, ok := interface{}(&req).(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return typedError(err, 400)
+			}
+		} else if v, ok := interface{}(req).(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return typedError(err, 400)
+			}
+		}
+
+		resp, err := handle(ctx, req)
+		if err != nil {
+			return typedError(err, 500)
+		}
+		return resp
+	}
+}
+
+func typedError(err error, status int) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{status, err.Error()}
+}
+
+// jsonFields adds the names of the struct fields that are in the JSON object,
+// the keys are matched case-insensitively like the encoding/json.
+func jsonFields(t reflect.Type, object map[string]json.RawMessage, fields map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			jsonFields(f.Type, object, fields)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		for key := range object {
+			if strings.EqualFold(key, name) {
+				fields[f.Name] = true
+			}
+		}
+	}
+}
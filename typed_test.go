@@ -0,0 +1,106 @@
+package rex
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type typedTestItem struct {
+	ID   int    `path:"1" json:"id"`
+	Name string `form:"name,required" json:"name"`
+	Page int    `form:"page" default:"1" json:"page"`
+	Q    string `form:"q" json:"q"`
+}
+
+func TestTypedDecode(t *testing.T) {
+	api := &APIHandler{}
+	api.Mutation("items/*", Typed(func(ctx *Context, req *typedTestItem) (*typedTestItem, error) {
+		return req, nil
+	}))
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		status      int
+		want        typedTestItem
+	}{
+		{
+			name:        "json body",
+			target:      "/items/5?q=x",
+			contentType: "application/json",
+			body:        `{"name":"a","page":3}`,
+			status:      200,
+			want:        typedTestItem{ID: 5, Name: "a", Page: 3, Q: "x"},
+		},
+		{
+			name:        "json body keeps the default",
+			target:      "/items/5",
+			contentType: "application/json",
+			body:        `{"NAME":"a"}`,
+			status:      200,
+			want:        typedTestItem{ID: 5, Name: "a", Page: 1},
+		},
+		{
+			name:        "json body with the form value",
+			target:      "/items/5?name=b&page=2",
+			contentType: "application/json",
+			body:        `{"page":3}`,
+			status:      200,
+			want:        typedTestItem{ID: 5, Name: "b", Page: 3},
+		},
+		{
+			name:        "path is not overwritten by the body",
+			target:      "/items/5",
+			contentType: "application/json",
+			body:        `{"id":9,"name":"a"}`,
+			status:      200,
+			want:        typedTestItem{ID: 5, Name: "a", Page: 1},
+		},
+		{
+			name:        "form body",
+			target:      "/items/5",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=b",
+			status:      200,
+			want:        typedTestItem{ID: 5, Name: "b", Page: 1},
+		},
+		{
+			name:        "missing name",
+			target:      "/items/5",
+			contentType: "application/json",
+			body:        `{"page":3}`,
+			status:      400,
+		},
+		{
+			name:        "invalid json",
+			target:      "/items/5",
+			contentType: "application/json",
+			body:        `{"name":`,
+			status:      400,
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.target, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, w.Code, w.Body.String(), test.status)
+			continue
+		}
+		if test.status != 200 {
+			continue
+		}
+		var got typedTestItem
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -21,6 +21,9 @@ type ServerConfig struct {
 	ReadTimeout    uint32    `json:"readTimeout"`
 	WriteTimeout   uint32    `json:"writeTimeout"`
 	MaxHeaderBytes uint32    `json:"maxHeaderBytes"`
+	// TrustedProxies is the CIDR list of the proxies allowed to set the
+	// `Forwarded`, `X-Forwarded-For` and `X-Real-IP` headers
+	TrustedProxies []string `json:"trustedProxies"`
 }
 
 // TLSConfig contains options to support https.
@@ -67,6 +70,11 @@ type Error struct {
 	Message string `json:"message"`
 }
 
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
 func Err(status int, v ...string) *Error {
 	var messsage string
 	if len(v) > 0 {
@@ -121,7 +129,8 @@ func Content(name string, mtime time.Time, r io.ReadSeeker) *contentful {
 	return &contentful{name, mtime, r}
 }
 
-// File replies to the request using the file content.
+// File replies to the request using the file content, the name is opened as it
+// is, use the ServeFS to serve the files by request paths.
 func File(name string) *contentful {
 	fi, err := os.Stat(name)
 	if err != nil {
@@ -166,13 +175,14 @@ func HTML(html string, data interface{}) *contentful {
 	}
 }
 
-type fs struct {
-	root     string
-	fallback string
-}
-
 // FS replies to the request with the contents of the file system rooted at root.
 func FS(root string, fallback string) interface{} {
+	return FSWithConfig(root, FSConfig{Fallback: fallback})
+}
+
+// FSWithConfig is like FS but with the config, like the Strict, Symlinks and
+// Dotfiles policies.
+func FSWithConfig(root string, config FSConfig) interface{} {
 	fi, err := os.Lstat(root)
 	if err != nil {
 		panic(&recoverError{500, err.Error()})
@@ -180,5 +190,5 @@ func FS(root string, fallback string) interface{} {
 	if !fi.IsDir() {
 		panic(&recoverError{500, "FS root is not a directory"})
 	}
-	return &fs{root, fallback}
+	return ServeFS(DirFS(root), config)
 }
@@ -0,0 +1,258 @@
+package rex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errBodyTooLarge       = &Error{http.StatusRequestEntityTooLarge, "request body too large"}
+	errFileTooLarge       = &Error{http.StatusRequestEntityTooLarge, "file too large"}
+	errTooManyFiles       = &Error{http.StatusRequestEntityTooLarge, "too many files"}
+	errFileTypeNotAllowed = &Error{http.StatusUnsupportedMediaType, "file type not allowed"}
+)
+
+// UploadLimits contains the limits of the multipart form.
+type UploadLimits struct {
+	// MaxMemory is the max bytes of the parsed form stored in memory, defaults to 32MB
+	MaxMemory int64
+	// MaxFileSize is the max bytes of each file
+	MaxFileSize int64
+	// MaxFiles is the max count of files
+	MaxFiles int
+	// AllowedTypes is the list of allowed MIME types of files, like "image/*"
+	AllowedTypes []string
+}
+
+func (limits UploadLimits) isTypeAllowed(contentType string) bool {
+	if len(limits.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range limits.AllowedTypes {
+		if t == mediaType || t == "*/*" || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// BodyLimit returns a BodyLimit middleware to limit the size of the request body,
+// it replies 413 for the larger requests.
+func BodyLimit(maxBytes int64) Handle {
+	return func(ctx *Context) interface{} {
+		if ctx.R.ContentLength > maxBytes {
+			return errBodyTooLarge
+		}
+		if ctx.R.Body != nil && ctx.R.Body != http.NoBody {
+			body := newLimitedBody(ctx.R.Body, maxBytes, ctx.Form.body)
+			ctx.R.Body = body
+			ctx.Form.body = body
+		}
+		return nil
+	}
+}
+
+// Upload returns a Upload middleware to set the limits of the multipart form.
+func Upload(limits UploadLimits) Handle {
+	return func(ctx *Context) interface{} {
+		ctx.Form.limits = limits
+		return nil
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+	// parent is the limit set by the previous middleware, e.g. the BodyLimit
+	// before the Decompress.
+	parent *limitedBody
+}
+
+// newLimitedBody chains the limit with the parent limit, the smaller one applies.
+func newLimitedBody(body io.ReadCloser, maxBytes int64, parent *limitedBody) *limitedBody {
+	if parent != nil && parent.remaining < maxBytes {
+		maxBytes = parent.remaining
+	}
+	return &limitedBody{ReadCloser: body, remaining: maxBytes, parent: parent}
+}
+
+// tooLarge reports whether the body or the parent body exceeds the limit.
+func (b *limitedBody) tooLarge() bool {
+	for ; b != nil; b = b.parent {
+		if b.exceeded {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err = b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.parent.tooLarge() {
+		// the decoder between the bodies may wrap the error of the parent
+		b.exceeded = true
+		return n, errBodyTooLarge
+	}
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.exceeded = true
+	return n, errBodyTooLarge
+}
+
+// FormParts is a streaming reader of the multipart form.
+type FormParts struct {
+	reader *multipart.Reader
+	body   *limitedBody
+	limits UploadLimits
+	files  int
+}
+
+// Parts returns a streaming reader of the multipart form, the files are never
+// buffered to memory or temporary files. It must be called before other methods
+// of the Form that parse the request body.
+func (form *Form) Parts() (*FormParts, error) {
+	if form.R.MultipartForm != nil || form.R.PostForm != nil {
+		return nil, errors.New("form already parsed")
+	}
+	reader, err := form.R.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	return &FormParts{reader: reader, body: form.body, limits: form.limits}, nil
+}
+
+// Next returns the next part of the form, or io.EOF if there are no more parts.
+func (fp *FormParts) Next() (*FormPart, error) {
+	part, err := fp.reader.NextPart()
+	if err != nil {
+		if fp.body.tooLarge() {
+			return nil, errBodyTooLarge
+		}
+		return nil, err
+	}
+	p := &FormPart{Part: part, reader: part, body: fp.body}
+	if part.FileName() != "" {
+		fp.files++
+		if fp.limits.MaxFiles > 0 && fp.files > fp.limits.MaxFiles {
+			return nil, errTooManyFiles
+		}
+		if !fp.limits.isTypeAllowed(part.Header.Get("Content-Type")) {
+			return nil, errFileTypeNotAllowed
+		}
+		if fp.limits.MaxFileSize > 0 {
+			p.reader = &limitedBody{ReadCloser: part, remaining: fp.limits.MaxFileSize}
+		}
+	}
+	return p, nil
+}
+
+// FormPart is a part of the multipart form.
+type FormPart struct {
+	*multipart.Part
+	reader io.Reader
+	body   *limitedBody
+}
+
+// IsFile checks the part whether is a file.
+func (p *FormPart) IsFile() bool {
+	return p.FileName() != ""
+}
+
+// Read reads the body of the part.
+func (p *FormPart) Read(b []byte) (n int, err error) {
+	n, err = p.reader.Read(b)
+	if err != nil && err != io.EOF {
+		if p.body.tooLarge() {
+			err = errBodyTooLarge
+		} else if lb, ok := p.reader.(*limitedBody); ok && lb.exceeded {
+			err = errFileTooLarge
+		}
+	}
+	return
+}
+
+// Value reads the part as a string value, up to 1MB.
+func (p *FormPart) Value() (string, error) {
+	buf := bytes.NewBuffer(nil)
+	n, err := io.Copy(buf, io.LimitReader(p, 1<<20+1))
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<20 {
+		return "", errBodyTooLarge
+	}
+	return buf.String(), nil
+}
+
+// SaveTo saves the part to the file.
+func (p *FormPart) SaveTo(filename string) (int64, error) {
+	return DiskStorage{}.Save(filename, p)
+}
+
+// Store saves the part to the storage with the name.
+func (p *FormPart) Store(storage FileStorage, name string) (int64, error) {
+	return storage.Save(name, p)
+}
+
+// A FileStorage interface to save the uploaded files.
+type FileStorage interface {
+	Save(name string, r io.Reader) (written int64, err error)
+}
+
+// DiskStorage saves the uploaded files in the Dir.
+type DiskStorage struct {
+	Dir string
+}
+
+// Save saves the file, the incomplete file is removed if it fails.
+func (s DiskStorage) Save(name string, r io.Reader) (int64, error) {
+	filename := name
+	if s.Dir != "" {
+		// keep the file in the dir
+		filename = filepath.Join(s.Dir, filepath.FromSlash(filepath.Clean("/"+name)))
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+package rex
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	api := &APIHandler{}
+	api.Mutation("form", BodyLimit(16), func(ctx *Context) interface{} {
+		return ctx.Form.Value("a")
+	})
+
+	tests := []struct {
+		body    string
+		chunked bool
+		status  int
+	}{
+		{body: "a=hello", status: 200},
+		{body: "a=" + strings.Repeat("x", 14), status: 200},
+		{body: "a=" + strings.Repeat("x", 15), status: 413},
+		// the body without the content length is limited on reading
+		{body: "a=hello", chunked: true, status: 200},
+		{body: "a=" + strings.Repeat("x", 15), chunked: true, status: 413},
+	}
+	for _, test := range tests {
+		var body io.Reader = strings.NewReader(test.body)
+		if test.chunked {
+			// hide the size from the httptest.NewRequest
+			body = io.MultiReader(body)
+		}
+		r := httptest.NewRequest("POST", "/form", body)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%d bytes chunked %v: got %d %s, want %d", len(test.body), test.chunked, w.Code, w.Body.String(), test.status)
+		}
+	}
+}
+
+type uploadTestFile struct {
+	name  string
+	ctype string
+	size  int
+}
+
+func uploadTestBody(t *testing.T, fields map[string]string, files []uploadTestFile) (*bytes.Buffer, string) {
+	buf := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buf)
+	for key, value := range fields {
+		mw.WriteField(key, value)
+	}
+	for _, file := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="`+file.name+`"`)
+		h.Set("Content-Type", file.ctype)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte("x"), file.size))
+	}
+	mw.Close()
+	return buf, mw.FormDataContentType()
+}
+
+func TestFormParts(t *testing.T) {
+	limits := UploadLimits{MaxFileSize: 100, MaxFiles: 2, AllowedTypes: []string{"image/*", "text/plain"}}
+	handle := func(ctx *Context) interface{} {
+		parts, err := ctx.Form.Parts()
+		if err != nil {
+			return err
+		}
+		var ret []string
+		for {
+			part, err := parts.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if part.IsFile() {
+				n, err := io.Copy(io.Discard, part)
+				if err != nil {
+					return err
+				}
+				ret = append(ret, part.FileName()+":"+strconv.FormatInt(n, 10))
+			} else {
+				value, err := part.Value()
+				if err != nil {
+					return err
+				}
+				ret = append(ret, part.FormName()+"="+value)
+			}
+		}
+		return strings.Join(ret, ",")
+	}
+	api := &APIHandler{}
+	api.Mutation("upload", Upload(limits), handle)
+	api.Mutation("limited", BodyLimit(1024), Upload(limits), handle)
+
+	tests := []struct {
+		path   string
+		fields map[string]string
+		files  []uploadTestFile
+		status int
+		body   string
+	}{
+		{
+			path:   "/upload",
+			fields: map[string]string{"title": "hello"},
+			files:  []uploadTestFile{{"a.png", "image/png", 100}, {"b.txt", "text/plain", 10}},
+			status: 200,
+			body:   "title=hello,a.png:100,b.txt:10",
+		},
+		{path: "/upload", files: []uploadTestFile{{"a.png", "image/png", 101}}, status: 413},
+		{path: "/upload", files: []uploadTestFile{{"a.png", "image/png", 1}, {"b.png", "image/png", 1}, {"c.png", "image/png", 1}}, status: 413},
+		{path: "/upload", files: []uploadTestFile{{"a.pdf", "application/pdf", 1}}, status: 415},
+		{path: "/limited", files: []uploadTestFile{{"a.png", "image/png", 100}}, status: 200, body: "a.png:100"},
+		{path: "/limited", fields: map[string]string{"title": strings.Repeat("x", 2048)}, status: 413},
+	}
+	for i, test := range tests {
+		body, ctype := uploadTestBody(t, test.fields, test.files)
+		// stream the body without the content length
+		r := httptest.NewRequest("POST", test.path, io.MultiReader(body))
+		r.Header.Set("Content-Type", ctype)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("#%d %s: got %d %s, want %d", i, test.path, w.Code, w.Body.String(), test.status)
+			continue
+		}
+		if test.body != "" && strings.TrimSpace(w.Body.String()) != test.body {
+			t.Errorf("#%d %s: got %q, want %q", i, test.path, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestFormFileLimits(t *testing.T) {
+	api := &APIHandler{}
+	api.Mutation("upload", Upload(UploadLimits{MaxFileSize: 100, AllowedTypes: []string{"image/*"}}), func(ctx *Context) interface{} {
+		_, fh, err := ctx.Form.File("file")
+		if err != nil {
+			return err
+		}
+		return fh.Filename
+	})
+
+	tests := []struct {
+		file   uploadTestFile
+		status int
+	}{
+		{uploadTestFile{"a.png", "image/png", 100}, 200},
+		{uploadTestFile{"a.png", "image/png", 101}, 413},
+		{uploadTestFile{"a.txt", "text/plain", 1}, 415},
+	}
+	for _, test := range tests {
+		body, ctype := uploadTestBody(t, nil, []uploadTestFile{test.file})
+		r := httptest.NewRequest("POST", "/upload", body)
+		r.Header.Set("Content-Type", ctype)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%v: got %d %s, want %d", test.file, w.Code, w.Body.String(), test.status)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rex
+
+import (
+	"net/http"
+)
+
+type handled struct{}
+
+// Wrap returns a Handle that hands off the request to the http.Handler, the
+// response is done after the handler returns. The request is not changed by the
+// Prefix of the APIHandler, use http.StripPrefix if the handler needs the
+// trimmed path.
+func Wrap(h http.Handler) Handle {
+	return func(ctx *Context) interface{} {
+		h.ServeHTTP(ctx.W, ctx.R)
+		return &handled{}
+	}
+}
+
+// FromMiddleware adapts the standard middleware to a Handle. The rest handles
+// are called by the next handler of the middleware with its response writer and
+// request, the response is done if the middleware doesn't call the next handler.
+func FromMiddleware(mw func(http.Handler) http.Handler) Handle {
+	return func(ctx *Context) interface{} {
+		c := ctx.chain
+		if c == nil {
+			return nil
+		}
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r != c.r {
+				c.form.R = r
+			}
+			c.w, c.r = w, r
+			ctx.next()
+		})).ServeHTTP(ctx.W, ctx.R)
+		return &handled{}
+	}
+}
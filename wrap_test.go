@@ -0,0 +1,97 @@
+package rex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type wrapTestKey struct{}
+
+func TestFromMiddleware(t *testing.T) {
+	api := &APIHandler{}
+	api.Use(FromMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Deny") != "" {
+				http.Error(w, "denied", 403)
+				return
+			}
+			w.Header().Set("X-Middleware", "1")
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), wrapTestKey{}, "value")))
+		})
+	}))
+	api.Query("value", func(ctx *Context) interface{} {
+		return ctx.R.Context().Value(wrapTestKey{})
+	})
+	api.Mutation("form", func(ctx *Context) interface{} {
+		// the form reads the replaced request
+		return ctx.Form.Value("a") + "," + ctx.R.Context().Value(wrapTestKey{}).(string)
+	})
+	api.Query("wrap/*", Wrap(http.StripPrefix("/wrap", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("wrapped " + r.URL.Path))
+	}))))
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		deny   bool
+		status int
+		want   string
+	}{
+		{method: "GET", target: "/value", status: 200, want: "value"},
+		{method: "GET", target: "/value", deny: true, status: 403, want: "denied"},
+		{method: "POST", target: "/form", body: "a=x", status: 200, want: "x,value"},
+		{method: "GET", target: "/wrap/a", status: 200, want: "wrapped /a"},
+		{method: "GET", target: "/missing", status: 404},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.body != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.deny {
+			r.Header.Set("X-Deny", "1")
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.method, test.target, w.Code, w.Body.String(), test.status, test.want)
+		}
+		if test.status == 200 && w.Header().Get("X-Middleware") != "1" {
+			t.Errorf("%s %s: the header of the middleware is lost", test.method, test.target)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	api := &APIHandler{Prefix: "v2"}
+	api.Query("items", func(ctx *Context) interface{} {
+		return "items " + ctx.Path.String()
+	})
+	mux := http.NewServeMux()
+	api.Mount(mux, "/api")
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mux"))
+	})
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{target: "/api/v2/items", status: 200, want: "items /items"},
+		{target: "/api/v2/missing", status: 404},
+		{target: "/items", status: 200, want: "mux"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: got %d %q, want %d %q", test.target, w.Code, w.Body.String(), test.status, test.want)
+		}
+	}
+}
@@ -15,6 +15,7 @@ type responseWriter struct {
 	compression io.WriteCloser
 	rawWriter   http.ResponseWriter
 	headerSent  bool
+	onClose     []func()
 }
 
 // Hijack lets the caller take over the connection.
@@ -44,7 +45,7 @@ func (w *responseWriter) WriteHeader(status int) {
 // Write writes the data to the connection as part of an HTTP reply.
 func (w *responseWriter) Write(p []byte) (n int, err error) {
 	if !w.headerSent {
-		w.headerSent = true
+		w.WriteHeader(w.status)
 	}
 	var wr io.Writer = w.rawWriter
 	if w.compression != nil {
@@ -57,9 +58,103 @@ func (w *responseWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
-func (w *responseWriter) Close() error {
+// ReadFrom reads data from r until EOF and writes it to the connection, the
+// io.ReaderFrom of the raw writer is used if the compression is disabled.
+func (w *responseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if !w.headerSent {
+		w.WriteHeader(w.status)
+	}
 	if w.compression != nil {
-		return w.compression.Close()
+		n, err = io.Copy(w.compression, r)
+	} else if rf, ok := w.rawWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.rawWriter, r)
+	}
+	if n > 0 {
+		w.written += int(n)
+	}
+	return
+}
+
+// Flush sends any buffered data to the client, the compressed data are flushed first.
+func (w *responseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError is like Flush but returns the error, it's used by the http.ResponseController.
+func (w *responseWriter) FlushError() error {
+	if !w.headerSent {
+		w.WriteHeader(w.status)
+	}
+	if f, ok := w.compression.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return flushWriter(w.rawWriter)
+}
+
+// flushWriter flushes the writer through the wrapped writers, like the
+// http.ResponseController of Go 1.20.
+func flushWriter(w http.ResponseWriter) error {
+	for {
+		switch a := w.(type) {
+		case interface{ FlushError() error }:
+			return a.FlushError()
+		case http.Flusher:
+			a.Flush()
+			return nil
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = a.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
+	}
+}
+
+// Push initiates an HTTP/2 server push.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.rawWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the raw response writer for the http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.rawWriter
+}
+
+func (w *responseWriter) Close() (err error) {
+	if w.compression != nil {
+		// the compressor is put back to the pool after closing
+		c := w.compression
+		w.compression = nil
+		err = c.Close()
+	}
+	for _, fn := range w.onClose {
+		fn()
+	}
+	return
+}
+
+// responseWriter returns the response writer of rex, the writers of the
+// standard middlewares are unwrapped.
+func (ctx *Context) responseWriter() (*responseWriter, bool) {
+	w := ctx.W
+	for w != nil {
+		if rw, ok := w.(*responseWriter); ok {
+			return rw, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	if ctx.chain != nil && ctx.chain.rw != nil {
+		return ctx.chain.rw, true
 	}
-	return nil
+	return nil, false
 }
@@ -0,0 +1,80 @@
+package rex
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writerTestWrapper wraps the response writer like the standard middlewares.
+type writerTestWrapper struct {
+	http.ResponseWriter
+}
+
+func (w *writerTestWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writerTestPlain is a response writer without the optional interfaces.
+type writerTestPlain struct {
+	header http.Header
+}
+
+func (w *writerTestPlain) Header() http.Header         { return w.header }
+func (w *writerTestPlain) Write(p []byte) (int, error) { return len(p), nil }
+func (w *writerTestPlain) WriteHeader(status int)      {}
+
+func TestResponseWriterFlush(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     func(rec *httptest.ResponseRecorder) http.ResponseWriter
+		flushed bool
+		err     error
+	}{
+		{name: "flusher", raw: func(rec *httptest.ResponseRecorder) http.ResponseWriter { return rec }, flushed: true},
+		{name: "wrapped", raw: func(rec *httptest.ResponseRecorder) http.ResponseWriter { return &writerTestWrapper{rec} }, flushed: true},
+		{name: "plain", raw: func(rec *httptest.ResponseRecorder) http.ResponseWriter { return &writerTestPlain{http.Header{}} }, err: http.ErrNotSupported},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		w := &responseWriter{status: 200, rawWriter: test.raw(rec)}
+		if err := w.FlushError(); err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+		if rec.Flushed != test.flushed {
+			t.Errorf("%s: got flushed %v, want %v", test.name, rec.Flushed, test.flushed)
+		}
+	}
+}
+
+func TestWrapPassthrough(t *testing.T) {
+	var errs []string
+	api := &APIHandler{}
+	api.Query("*", Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if _, ok := w.(io.ReaderFrom); !ok {
+			errs = append(errs, "not an io.ReaderFrom")
+		}
+		io.Copy(w, strings.NewReader("hello"))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		} else {
+			errs = append(errs, "not a http.Flusher")
+		}
+		if p, ok := w.(http.Pusher); !ok || p.Push("/app.js", nil) != http.ErrNotSupported {
+			errs = append(errs, "push is not passed through")
+		}
+		if _, ok := w.(http.Hijacker); !ok {
+			errs = append(errs, "not a http.Hijacker")
+		}
+	})))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != "hello" || !w.Flushed || len(errs) > 0 {
+		t.Fatalf("got %d %q flushed %v %v", w.Code, w.Body.String(), w.Flushed, errs)
+	}
+}